@@ -3,10 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"shellchat/config"
 	"shellchat/p2p"
 	"shellchat/ui"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +19,13 @@ var chatCmd = &cobra.Command{
 		// Peer discovery needs to know port? mDNS handles it.
 		// Randomness for key generation
 
+		cfg, err := config.Load()
+		if err != nil {
+			fmt.Println("Warning:", err)
+		}
+
 		fmt.Println("Initializing P2P Node...")
-		h, err := p2p.MakeHost(0, nil) // nil randomness = crypto/rand
+		h, err := p2p.MakeHost(0, nil, cfg.Network) // nil randomness = crypto/rand
 		if err != nil {
 			fmt.Printf("Failed to create host: %v\n", err)
 			os.Exit(1)
@@ -29,18 +34,28 @@ var chatCmd = &cobra.Command{
 		fmt.Printf("I am %s\n", h.P2PHost.ID().String())
 
 		// Start Discovery
-		if err := p2p.SetupDiscovery(h.P2PHost, h.DHT); err != nil {
+		if err := p2p.SetupDiscovery(h); err != nil {
 			fmt.Printf("Failed to start discovery: %v\n", err)
 		}
 
-		p := tea.NewProgram(ui.InitialModel(h))
-		if _, err := p.Run(); err != nil {
+		if chatAccessible {
+			if err := ui.RunAccessible(h, cfg); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if _, err := ui.NewProgram(h, cfg).Run(); err != nil {
 			fmt.Printf("Alas, there's been an error: %v", err)
 			os.Exit(1)
 		}
 	},
 }
 
+var chatAccessible bool
+
 func init() {
+	chatCmd.Flags().BoolVar(&chatAccessible, "accessible", false, "plain line-by-line transcript for screen readers and braille displays")
 	rootCmd.AddCommand(chatCmd)
 }
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"shellchat/config"
+	"shellchat/p2p"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorWait time.Duration
+	doctorJSON bool
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose network connectivity",
+	Long: `Starts a temporary P2P node, watches it for a while and reports bootstrap
+connectivity, DHT health, NAT reachability, port mappings, mDNS and which
+transports are reachable from the outside, with hints on how to fix problems.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil && !doctorJSON {
+			fmt.Println("Warning:", err)
+		}
+
+		h, err := p2p.MakeHost(0, nil, cfg.Network)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create host: %v\n", err)
+			os.Exit(1)
+		}
+		defer h.P2PHost.Close()
+
+		// mDNS failures are part of the report, not fatal.
+		p2p.SetupDiscovery(h)
+
+		if !doctorJSON {
+			fmt.Printf("Probing network for %s...\n\n", doctorWait)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), doctorWait)
+		defer cancel()
+
+		report, err := h.Diagnose(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Diagnosis failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if doctorJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write the report: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		printDiagnostics(report)
+	},
+}
+
+func printDiagnostics(d *p2p.Diagnostics) {
+	fmt.Printf("Peer ID: %s\n", d.PeerID)
+
+	section("BOOTSTRAP")
+	pending := ""
+	if d.BootstrapPending {
+		pending = " (still dialing)"
+	}
+	fmt.Printf("Connected to %d of %d bootstrap peers%s\n", d.BootstrapConnected, len(d.Bootstrap), pending)
+	for _, b := range d.Bootstrap {
+		if b.Error != "" {
+			fmt.Printf("  FAIL %s: %s\n", b.Peer, b.Error)
+		}
+	}
+	fmt.Printf("DHT routing table: %d peers\n", d.RoutingTableSize)
+	if d.DiscoveryError != "" {
+		fmt.Printf("Last discovery error: %s\n", d.DiscoveryError)
+	}
+
+	section("NAT")
+	fmt.Printf("AutoNAT reachability: %s\n", d.Reachability)
+	if d.NAT.DeviceFound {
+		fmt.Printf("UPnP/NAT-PMP gateway found, %d port mappings\n", len(d.NAT.Mappings))
+		for _, m := range d.NAT.Mappings {
+			fmt.Printf("  %s -> %s\n", m.Listen, m.External)
+		}
+	} else {
+		fmt.Println("No UPnP/NAT-PMP gateway found")
+	}
+	for proto, t := range d.NAT.DeviceTypes {
+		fmt.Printf("NAT type (%s): %s\n", proto, t)
+	}
+	if len(d.ObservedAddrs) == 0 {
+		fmt.Println("Observed addresses: none yet")
+	} else {
+		fmt.Println("Observed addresses:")
+		for _, addr := range d.ObservedAddrs {
+			fmt.Println("  " + addr)
+		}
+	}
+
+	section("MDNS")
+	switch {
+	case d.MDNS.Error != "":
+		fmt.Printf("Not running: %s\n", d.MDNS.Error)
+	case d.MDNS.Running:
+		fmt.Printf("Running, %d local peers found\n", d.MDNS.PeersFound)
+	default:
+		fmt.Println("Not started")
+	}
+
+	section("TRANSPORTS")
+	for _, t := range d.Transports {
+		fmt.Printf("%-13s %-12s %d listening\n", strings.ToUpper(t.Transport), t.Status, len(t.Listening))
+		for _, addr := range t.Reachable {
+			fmt.Println("  reachable:   " + addr)
+		}
+		for _, addr := range t.Unreachable {
+			fmt.Println("  unreachable: " + addr)
+		}
+	}
+
+	if len(d.Hints) > 0 {
+		section("HINTS")
+		for _, hint := range d.Hints {
+			fmt.Println("* " + hint)
+		}
+	}
+}
+
+func section(title string) {
+	fmt.Printf("\n%s\n%s\n", title, strings.Repeat("-", len(title)))
+}
+
+func init() {
+	doctorCmd.Flags().DurationVar(&doctorWait, "wait", 30*time.Second, "how long to observe the network")
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "print the report as JSON")
+	rootCmd.AddCommand(doctorCmd)
+}
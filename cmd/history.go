@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"shellchat/storage"
+	"shellchat/session"
 	"syscall"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/argon2"
 	"golang.org/x/term"
 )
 
@@ -25,11 +24,6 @@ var clearHistoryCmd = &cobra.Command{
 		password := string(bytePassword)
 		fmt.Println()
 
-		// Derive key (same logic as init)
-		salt := []byte("shellchat-static-salt")
-		key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-		hexKey := fmt.Sprintf("x'%x'", key)
-
 		// Open DB
 		userConfigDir, err := os.UserConfigDir()
 		if err != nil {
@@ -37,13 +31,14 @@ var clearHistoryCmd = &cobra.Command{
 			return
 		}
 
-		if err := storage.InitDB(userConfigDir, hexKey); err != nil {
+		st, err := session.OpenHistory(userConfigDir, password)
+		if err != nil {
 			fmt.Println("Failed to open database (wrong password?):", err)
 			return
 		}
-		defer storage.CloseDB()
+		defer st.Close()
 
-		if err := storage.ClearHistory(); err != nil {
+		if err := st.ClearHistory(cmd.Context()); err != nil {
 			fmt.Println("Failed to clear history:", err)
 			return
 		}
@@ -5,12 +5,11 @@ import (
 	"os"
 	"syscall"
 
-	"shellchat/storage"
+	"shellchat/session"
 
 	"golang.org/x/term"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/argon2"
 )
 
 var initCmd = &cobra.Command{
@@ -40,30 +39,19 @@ var initCmd = &cobra.Command{
 			return
 		}
 
-		// Derive key using Argon2 (for demonstration, we use the password directly as key for SQLCipher pragma,
-		// but typically you'd salt and hash it. SQLCipher handles key derivation internally if provided a raw key,
-		// but providing a consistent hashed key is also good practice if we want to change KDF settings manually.
-		// However, SQLCipher's default KDF is PBKDF2.
-		// The prompt requirement says: "Hash this password using argon2 to derive the AES encryption key".
-		// SQLCipher `PRAGMA key` accepts a hex string for raw key.
-		// Let's derive a 32-byte key and encode as known format for SQLCipher.
-
-		salt := []byte("shellchat-static-salt") // In production, this should be random and stored.
-		key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-		hexKey := fmt.Sprintf("x'%x'", key)
-
 		userConfigDir, err := os.UserConfigDir()
 		if err != nil {
 			fmt.Println("Failed to get user config dir", err)
 			return
 		}
 
-		// Initialize DB with the derived key
-		if err := storage.InitDB(userConfigDir, hexKey); err != nil {
+		// Initialize DB with a key derived from the password
+		st, err := session.OpenHistory(userConfigDir, password)
+		if err != nil {
 			fmt.Println("Failed to initialize database:", err)
 			return
 		}
-		defer storage.CloseDB()
+		defer st.Close()
 
 		fmt.Println("Database initialized and encrypted successfully.")
 	},
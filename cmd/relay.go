@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"shellchat/config"
+	"shellchat/p2p"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	relayPort            int
+	relayIdentity        string
+	relayMaxConns        int
+	relayMaxMemoryMB     int
+	relayMaxReservations int
+	relayMaxCircuits     int
+	relayForcePublic     bool
+)
+
+var relayCmd = &cobra.Command{
+	Use:     "relay",
+	Aliases: []string{"bootstrap"},
+	Short:   "Run a headless bootstrap / relay node",
+	Long: `Runs a persistent-identity node that acts as a DHT server, circuit relay v2
+relay and AutoNAT service for other ShellChat peers. It has no UI and stores no chat data.
+
+Add the printed addresses to "network.bootstrap_peers" in config.json on each client.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil {
+			fmt.Println("Warning:", err)
+		}
+		rc := cfg.Relay
+
+		// Flags override config.json
+		flags := cmd.Flags()
+		if flags.Changed("port") {
+			rc.Port = relayPort
+		}
+		if flags.Changed("identity") {
+			rc.IdentityFile = relayIdentity
+		}
+		if flags.Changed("max-conns") {
+			rc.MaxConns = relayMaxConns
+		}
+		if flags.Changed("max-memory") {
+			rc.MaxMemoryMB = relayMaxMemoryMB
+		}
+		if flags.Changed("max-reservations") {
+			rc.MaxReservations = relayMaxReservations
+		}
+		if flags.Changed("max-circuits") {
+			rc.MaxCircuits = relayMaxCircuits
+		}
+
+		if rc.IdentityFile == "" {
+			dir, err := config.Dir()
+			if err != nil {
+				fmt.Println("Error finding config directory:", err)
+				os.Exit(1)
+			}
+			rc.IdentityFile = filepath.Join(dir, "relay.key")
+		}
+
+		priv, err := p2p.LoadOrCreateIdentity(rc.IdentityFile)
+		if err != nil {
+			fmt.Println("Failed to load identity:", err)
+			os.Exit(1)
+		}
+
+		circuitDuration, err := time.ParseDuration(rc.CircuitDuration)
+		if err != nil {
+			fmt.Printf("Invalid relay.circuit_duration %q: %v\n", rc.CircuitDuration, err)
+			os.Exit(1)
+		}
+
+		// A relay only joins the networks it is told about; an empty list
+		// makes it the root of a private deployment.
+		bootstrap, err := p2p.ParseBootstrapPeers(cfg.Network)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(cfg.Network.BootstrapPeers) == 0 {
+			bootstrap = nil
+		}
+
+		node, err := p2p.MakeRelayNode(rc.Port, priv, bootstrap, p2p.RelayLimits{
+			MaxConns:        rc.MaxConns,
+			MaxMemoryMB:     rc.MaxMemoryMB,
+			MaxReservations: rc.MaxReservations,
+			MaxCircuits:     rc.MaxCircuits,
+			CircuitDuration: circuitDuration,
+			CircuitData:     rc.CircuitDataKB << 10,
+		}, relayForcePublic)
+		if err != nil {
+			fmt.Printf("Failed to start relay: %v\n", err)
+			os.Exit(1)
+		}
+		defer node.Close()
+
+		fmt.Printf("Relay node %s running\n", node.P2PHost.ID())
+		fmt.Print(p2p.FormatAddrGroups(node.AddrGroups()))
+		fmt.Printf("Limits: %d conns, %d MB, %d reservations, %d circuits/peer\n",
+			rc.MaxConns, rc.MaxMemoryMB, rc.MaxReservations, rc.MaxCircuits)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		fmt.Println("\nShutting down relay.")
+	},
+}
+
+func init() {
+	relayCmd.Flags().IntVarP(&relayPort, "port", "p", 4001, "TCP/UDP port to listen on")
+	relayCmd.Flags().StringVar(&relayIdentity, "identity", "", "path to the node's private key (default: <config dir>/shellchat/relay.key)")
+	relayCmd.Flags().IntVar(&relayMaxConns, "max-conns", 1024, "maximum number of open connections")
+	relayCmd.Flags().IntVar(&relayMaxMemoryMB, "max-memory", 512, "maximum memory for the libp2p resource manager, in MB")
+	relayCmd.Flags().IntVar(&relayMaxReservations, "max-reservations", 128, "maximum number of active relay reservations")
+	relayCmd.Flags().IntVar(&relayMaxCircuits, "max-circuits", 16, "maximum relayed connections per peer")
+	relayCmd.Flags().BoolVar(&relayForcePublic, "force-public", true, "assume the node is publicly reachable instead of waiting for AutoNAT")
+	rootCmd.AddCommand(relayCmd)
+}
@@ -2,15 +2,41 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"shellchat/config"
+	"shellchat/logging"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	logLevel  string
+	logCloser io.Closer
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "shellchat",
 	Short: "Zero-server P2P encrypted chat",
 	Long:  `ShellChat is a peer-to-peer encrypted chat application with zero central server.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := config.Dir()
+		if err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("log-level") {
+			if cfg, err := config.Load(); err == nil && cfg.LogLevel != "" {
+				logLevel = cfg.LogLevel
+			}
+		}
+		logCloser, err = logging.Setup(dir, logLevel)
+		return err
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if logCloser != nil {
+			logCloser.Close()
+		}
+	},
 }
 
 func Execute() {
@@ -19,3 +45,7 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level written to shellchat.log (debug, info, warn, error)")
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Arg says what a command's argument is, for help and completion.
+type Arg int
+
+const (
+	ArgNone   Arg = iota
+	ArgPeer       // a peer ID or multiaddr
+	ArgPath       // a local file
+	ArgFile       // the name of a received file
+	ArgStatus     // a presence state, optionally followed by text
+	ArgEmoji      // a single emoji
+	ArgTheme      // the name of a color theme
+	ArgOffer      // the name of a file a peer offers
+)
+
+// Command is one slash command. Front-ends attach their own handlers to
+// these through a Registry, so both offer the same names and help.
+type Command struct {
+	Name     string // without the slash
+	Usage    string // argument synopsis, e.g. "<addr>"
+	Help     string
+	Arg      Arg
+	Optional bool // the argument may be left out
+}
+
+// All lists every command in the order help shows them.
+var All = []Command{
+	{Name: "myid", Help: "Show your P2P addresses"},
+	{Name: "copyid", Help: "Copy your addresses to the clipboard"},
+	{Name: "connect", Usage: "<addr>", Help: "Connect to a peer by address or Peer ID", Arg: ArgPeer},
+	{Name: "peers", Help: "List connected peers"},
+	{Name: "nick", Usage: "<peer> [name]", Help: "Give a peer a one-word nickname, or remove it", Arg: ArgPeer},
+	{Name: "send", Usage: "<path>", Help: "Send a file to the open conversation", Arg: ArgPath},
+	{Name: "save", Usage: "<name>", Help: "Save a received file to ~/Downloads", Arg: ArgFile},
+	{Name: "accept", Usage: "[name]", Help: "Accept the latest file offer, or the one called name", Arg: ArgOffer, Optional: true},
+	{Name: "decline", Usage: "[name]", Help: "Decline the latest file offer, or the one called name", Arg: ArgOffer, Optional: true},
+	{Name: "thread", Help: "Show the thread of the latest reply"},
+	{Name: "react", Usage: "<emoji>", Help: "React to the latest message, or take it back", Arg: ArgEmoji},
+	{Name: "raw", Help: "Toggle between Markdown and raw message text"},
+	{Name: "mute", Help: "Mute or unmute notifications from the open peer conversation"},
+	{Name: "status", Usage: "[state] [text]", Help: "Show or set your status (state is online, away or dnd)", Arg: ArgStatus, Optional: true},
+	{Name: "theme", Usage: "[name]", Help: "Show the color themes or switch to one", Arg: ArgTheme, Optional: true},
+	{Name: "logs", Help: "Toggle the recent log pane"},
+	{Name: "clear", Help: "Clear the messages on screen"},
+	{Name: "wipe", Help: "Delete the chat history and received files on this device, after asking"},
+	{Name: "lock", Help: "Lock the history until the password is entered again"},
+	{Name: "exit", Help: "Return to the global room"},
+	{Name: "quit", Help: "Exit the application"},
+	{Name: "help", Help: "Show this help message"},
+}
+
+// ErrUnknown is returned for slash commands nobody registered.
+var ErrUnknown = errors.New("unknown command")
+
+// Find returns the command called name, without the slash.
+func Find(name string) (Command, bool) {
+	for _, c := range All {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// IsCommand reports whether input should be run rather than sent. A
+// doubled slash escapes one, so "//shrug" is sent as "/shrug".
+func IsCommand(input string) bool {
+	return strings.HasPrefix(input, "/") && !strings.HasPrefix(input, "//")
+}
+
+// Unescape returns message input as it is sent: without the first slash
+// of a leading "//".
+func Unescape(input string) string {
+	if strings.HasPrefix(input, "//") {
+		return input[1:]
+	}
+	return input
+}
+
+// Parse splits command input into the name, without the slash, and the
+// trimmed argument.
+func Parse(input string) (name, arg string) {
+	name, arg, _ = strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	return name, strings.TrimSpace(arg)
+}
+
+// Registry binds the commands a front-end supports to its handlers. H is
+// the front-end's handler type.
+type Registry[H any] struct {
+	handlers map[string]H
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry[H any]() *Registry[H] {
+	return &Registry[H]{handlers: make(map[string]H)}
+}
+
+// Handle registers h for the command called name, which must be in All.
+func (r *Registry[H]) Handle(name string, h H) {
+	if _, ok := Find(name); !ok {
+		panic("commands: unknown command " + name)
+	}
+	r.handlers[name] = h
+}
+
+// Commands returns the registered commands in help order.
+func (r *Registry[H]) Commands() []Command {
+	var cmds []Command
+	for _, c := range All {
+		if _, ok := r.handlers[c.Name]; ok {
+			cmds = append(cmds, c)
+		}
+	}
+	return cmds
+}
+
+// Lookup finds the handler for command input and checks its argument.
+func (r *Registry[H]) Lookup(input string) (Command, H, string, error) {
+	var zero H
+	name, arg := Parse(input)
+	h, ok := r.handlers[name]
+	if !ok {
+		return Command{}, zero, "", fmt.Errorf("%w /%s, see /help", ErrUnknown, name)
+	}
+	cmd, _ := Find(name)
+	if cmd.Arg != ArgNone && !cmd.Optional && arg == "" {
+		return cmd, zero, "", fmt.Errorf("usage: %s", cmd.Synopsis())
+	}
+	return cmd, h, arg, nil
+}
+
+// Synopsis returns the command as it is typed, e.g. "/connect <addr>".
+func (c Command) Synopsis() string {
+	if c.Usage == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Usage
+}
+
+// Help formats the registered commands as an aligned list.
+func (r *Registry[H]) Help() string {
+	cmds := r.Commands()
+	width := 0
+	for _, c := range cmds {
+		width = max(width, len(c.Synopsis()))
+	}
+	var sb strings.Builder
+	for _, c := range cmds {
+		fmt.Fprintf(&sb, "%-*s - %s\n", width, c.Synopsis(), c.Help)
+	}
+	return sb.String()
+}
+
+// Complete returns the registered command names starting with prefix.
+func (r *Registry[H]) Complete(prefix string) []string {
+	var names []string
+	for _, c := range r.Commands() {
+		if strings.HasPrefix(c.Name, prefix) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Search ranks the registered commands by how well query fuzzy-matches
+// their name and help, best first. An empty query returns them all.
+func (r *Registry[H]) Search(query string) []Command {
+	type match struct {
+		cmd   Command
+		score int
+	}
+	var matches []match
+	for i, c := range r.Commands() {
+		score, ok := Fuzzy(query, c.Name)
+		if !ok {
+			// Matches in the help text rank below any name match, and
+			// short queries match too much of it to be useful
+			if len(query) < 3 {
+				continue
+			}
+			if score, ok = Fuzzy(query, c.Help); !ok {
+				continue
+			}
+			score -= 1000
+		}
+		matches = append(matches, match{c, score*100 - i})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	cmds := make([]Command, len(matches))
+	for i, m := range matches {
+		cmds[i] = m.cmd
+	}
+	return cmds
+}
+
+// Fuzzy reports whether the characters of query appear in s in order,
+// ignoring case, and scores the match: consecutive characters and ones at
+// the start of words count more, gaps count against it.
+func Fuzzy(query, s string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	if len(q) == 0 {
+		return 0, true
+	}
+	score, qi, last := 0, 0, -1
+	prev := ' '
+	for i, r := range strings.ToLower(s) {
+		if qi < len(q) && r == q[qi] {
+			switch {
+			case last >= 0 && i == last+1:
+				score += 5
+			case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+				score += 3
+			default:
+				score -= min(i-last, 3)
+			}
+			qi++
+			last = i
+		}
+		prev = r
+	}
+	return score, qi == len(q)
+}
@@ -0,0 +1,27 @@
+package commands
+
+import "testing"
+
+func TestIsCommandEscape(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		input   string
+		command bool
+		sent    string
+	}{
+		{"/help", true, ""},
+		{"hello", false, "hello"},
+		{"//shrug", false, "/shrug"},
+		{"//etc/hosts", false, "/etc/hosts"},
+		{"a // b", false, "a // b"},
+	} {
+		if got := IsCommand(tc.input); got != tc.command {
+			t.Errorf("IsCommand(%q) = %t, want %t", tc.input, got, tc.command)
+		}
+		if !tc.command {
+			if got := Unescape(tc.input); got != tc.sent {
+				t.Errorf("Unescape(%q) = %q, want %q", tc.input, got, tc.sent)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const fileName = "config.json"
+
+// Config holds the user editable settings stored in config.json
+// inside the shellchat data directory.
+type Config struct {
+	// LogLevel is the default for --log-level (debug, info, warn, error).
+	LogLevel      string        `json:"log_level,omitempty"`
+	Network       Network       `json:"network"`
+	Relay         Relay         `json:"relay"`
+	Privacy       Privacy       `json:"privacy"`
+	Presence      Presence      `json:"presence"`
+	Notifications Notifications `json:"notifications"`
+	Files         Files         `json:"files"`
+	// Theme names the color theme, built in or from the themes directory.
+	// Defaults to "green".
+	Theme string `json:"theme"`
+	// Keys rebinds TUI actions by name, e.g. {"help": ["f1"]}. Each entry
+	// replaces all default keys of that action.
+	Keys map[string][]string `json:"keys,omitempty"`
+}
+
+// Network controls how chat nodes join the P2P network.
+type Network struct {
+	// BootstrapPeers replaces the public IPFS bootstrap list when set.
+	// Point this at your own `shellchat relay` nodes for private deployments.
+	BootstrapPeers []string `json:"bootstrap_peers,omitempty"`
+	// StaticRelays are circuit relay v2 nodes tried before relays found
+	// through the DHT when this node is behind a NAT.
+	StaticRelays []string `json:"static_relays,omitempty"`
+	// ListenAddrs replaces the default TCP and QUIC listeners on IPv4 and IPv6.
+	ListenAddrs []string `json:"listen_addrs,omitempty"`
+	// WebTransport adds WebTransport listeners to the default set.
+	WebTransport bool `json:"webtransport,omitempty"`
+}
+
+// Privacy controls what the chat front-ends reveal to peers, and what the
+// history reveals to anyone holding its file.
+type Privacy struct {
+	// TypingIndicators sends and shows "is typing" notices. Defaults to true.
+	TypingIndicators bool `json:"typing_indicators"`
+	// AutoLock locks the TUI after this long without input, wiping the
+	// history key from memory, e.g. "15m". Empty or "0" disables it.
+	AutoLock string `json:"auto_lock"`
+	// EncryptMetadata encrypts peer IDs and timestamps in the history, not
+	// just message content. Existing histories are migrated on unlock; it
+	// cannot be turned off again.
+	EncryptMetadata bool `json:"encrypt_metadata,omitempty"`
+}
+
+// Presence controls how our presence state changes on its own.
+type Presence struct {
+	// AutoAway switches the TUI to "away" after this long without input,
+	// e.g. "5m". Empty or "0" disables it.
+	AutoAway string `json:"auto_away"`
+}
+
+// Notifications controls how incoming messages are announced.
+type Notifications struct {
+	// Method is how the TUI notifies: "bell", "osc9" or "osc777" terminal
+	// notifications, "dbus" for the Linux desktop, or "off". The GUI uses
+	// desktop notifications unless this is "off". Defaults to "bell".
+	Method string `json:"method"`
+	// ShowContent puts the message text in notifications. By default they
+	// only name the sender.
+	ShowContent bool `json:"show_content"`
+	// QuietHours is a daily do-not-disturb window in local time, e.g.
+	// "22:00-07:00". Empty disables it.
+	QuietHours string `json:"quiet_hours,omitempty"`
+}
+
+// Files controls the files peers send us.
+type Files struct {
+	// QuotaMB caps the disk space received files take, in MB. Offers that
+	// do not fit are refused. Zero means no limit. Defaults to 2048.
+	QuotaMB int64 `json:"quota_mb"`
+	// ExpirePartial deletes incomplete transfers that were not resumed for
+	// this long, e.g. "168h". Empty or "0" keeps them. Defaults to "168h".
+	ExpirePartial string `json:"expire_partial"`
+}
+
+// Relay configures the `shellchat relay` command.
+type Relay struct {
+	Port            int    `json:"port"`
+	IdentityFile    string `json:"identity_file,omitempty"`
+	MaxConns        int    `json:"max_conns"`
+	MaxMemoryMB     int    `json:"max_memory_mb"`
+	MaxReservations int    `json:"max_reservations"`
+	MaxCircuits     int    `json:"max_circuits"`
+	// CircuitDuration and CircuitDataKB limit each relayed connection.
+	CircuitDuration string `json:"circuit_duration"`
+	CircuitDataKB   int64  `json:"circuit_data_kb"`
+}
+
+// Default returns the settings used when no config file exists.
+func Default() *Config {
+	return &Config{
+		Relay: Relay{
+			Port:            4001,
+			MaxConns:        1024,
+			MaxMemoryMB:     512,
+			MaxReservations: 128,
+			MaxCircuits:     16,
+			CircuitDuration: "2m",
+			CircuitDataKB:   128,
+		},
+		Privacy: Privacy{
+			TypingIndicators: true,
+			AutoLock:         "15m",
+		},
+		Presence: Presence{
+			AutoAway: "5m",
+		},
+		Notifications: Notifications{
+			Method: "bell",
+		},
+		Files: Files{
+			QuotaMB:       2048,
+			ExpirePartial: "168h",
+		},
+		Theme: "green",
+	}
+}
+
+// Dir returns the shellchat data directory inside the user config directory.
+func Dir() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userConfigDir, "shellchat"), nil
+}
+
+// Load reads config.json from the data directory.
+// A missing file is not an error; the defaults are returned instead.
+func Load() (*Config, error) {
+	dir, err := Dir()
+	if err != nil {
+		return Default(), err
+	}
+	return LoadFile(filepath.Join(dir, fileName))
+}
+
+// LoadFile reads the config from path, filling unset fields with defaults.
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return Default(), fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
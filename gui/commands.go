@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"shellchat/commands"
+	"shellchat/p2p"
+	"shellchat/storage"
+
+	"fyne.io/fyne/v2/dialog"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// commandHandler runs one slash command on the UI goroutine.
+type commandHandler func(c *chatApp, arg string)
+
+var guiCommands = commands.NewRegistry[commandHandler]()
+
+func init() {
+	guiCommands.Handle("myid", func(c *chatApp, _ string) {
+		fullAddr := strings.Join(c.host.FullAddrs(), "\n")
+		c.w.Clipboard().SetContent(fullAddr)
+		dialog.ShowInformation("IDs Copied", p2p.FormatAddrGroups(c.host.AddrGroups()), c.w)
+	})
+	guiCommands.Handle("copyid", func(c *chatApp, _ string) {
+		c.w.Clipboard().SetContent(strings.Join(c.host.FullAddrs(), "\n"))
+		dialog.ShowInformation("IDs Copied", "Addresses copied to clipboard.", c.w)
+	})
+	guiCommands.Handle("connect", (*chatApp).connect)
+	guiCommands.Handle("peers", func(c *chatApp, _ string) {
+		var peerList string
+		for _, p := range c.host.ChatPeers() {
+			peerList += p + "\n"
+		}
+		if peerList == "" {
+			peerList = "No peers connected."
+		}
+		dialog.ShowInformation("Connected Peers", peerList, c.w)
+	})
+	guiCommands.Handle("nick", (*chatApp).setNickname)
+	guiCommands.Handle("react", (*chatApp).react)
+	guiCommands.Handle("mute", func(c *chatApp, _ string) {
+		muted, err := c.session.ToggleMute(c.activePeer)
+		if err != nil {
+			dialog.ShowError(err, c.w)
+			return
+		}
+		text := "Notifications unmuted for this conversation."
+		if muted {
+			text = "Notifications muted for this conversation."
+		}
+		dialog.ShowInformation("Mute", text, c.w)
+	})
+	guiCommands.Handle("status", func(c *chatApp, arg string) {
+		if arg != "" {
+			c.session.SetStatus(arg)
+		}
+		state, text := c.session.Status()
+		if text != "" {
+			state += " - " + text
+		}
+		dialog.ShowInformation("Status", state, c.w)
+		c.refreshStatus()
+	})
+	guiCommands.Handle("theme", (*chatApp).switchTheme)
+	guiCommands.Handle("clear", func(c *chatApp, _ string) {
+		c.mu.Lock()
+		c.messages = []storage.Message{}
+		c.mu.Unlock()
+		c.msgList.Refresh()
+	})
+	guiCommands.Handle("wipe", func(c *chatApp, _ string) {
+		text := "Delete every message and received file on this device?\nThis cannot be undone."
+		dialog.ShowConfirm("Delete History", text, func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := c.session.Clear(); err != nil {
+				dialog.ShowError(err, c.w)
+				return
+			}
+			c.mu.Lock()
+			c.messages = []storage.Message{}
+			c.mu.Unlock()
+			c.msgList.Refresh()
+			c.refreshPeers()
+			dialog.ShowInformation("History Deleted", "History deleted locally.", c.w)
+		}, c.w)
+	})
+	guiCommands.Handle("lock", func(c *chatApp, _ string) {
+		if err := c.session.Lock(); err != nil {
+			dialog.ShowError(err, c.w)
+			return
+		}
+		c.mu.Lock()
+		c.messages = nil
+		c.mu.Unlock()
+		clear(c.transfers)
+		c.showLogin()
+	})
+	guiCommands.Handle("exit", func(c *chatApp, _ string) {
+		// The global room is always first in the list
+		c.peerList.Select(0)
+	})
+	guiCommands.Handle("quit", func(c *chatApp, _ string) {
+		c.a.Quit()
+	})
+	guiCommands.Handle("help", func(c *chatApp, _ string) {
+		dialog.ShowInformation("Help", "Available Commands:\n"+guiCommands.Help(), c.w)
+	})
+}
+
+// runCommand runs a line starting with a slash.
+func (c *chatApp) runCommand(input string) {
+	_, handle, arg, err := guiCommands.Lookup(input)
+	if err != nil {
+		dialog.ShowError(err, c.w)
+		return
+	}
+	handle(c, arg)
+}
+
+// connect handles /connect with a multiaddr or a bare Peer ID, which is
+// looked up in the DHT first.
+func (c *chatApp) connect(addrStr string) {
+	// 1. Try valid Multiaddr
+	ma, err := multiaddr.NewMultiaddr(addrStr)
+	if err == nil {
+		pi, err := peer.AddrInfoFromP2pAddr(ma)
+		if err == nil {
+			// The outcome is reported by handlePeerEvent
+			c.connecting = pi.ID.String()
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := c.host.Connect(ctx, *pi, "user"); err != nil {
+					logger.Warn("connect failed", "peer", pi.ID, "err", err)
+				}
+			}()
+			return
+		}
+	}
+
+	// 2. Try Peer ID (DHT Lookup)
+	pid, err := peer.Decode(addrStr)
+	if err == nil {
+		c.connecting = pid.String()
+		c.status.SetText("Looking up peer in DHT...")
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			pi, err := c.host.FindPeer(ctx, pid)
+			if err != nil {
+				logger.Warn("DHT lookup failed", "peer", pid, "err", err)
+				return
+			}
+			if err := c.host.Connect(ctx, pi, "user"); err != nil {
+				logger.Warn("connect failed", "peer", pid, "err", err)
+			}
+		}()
+		return
+	}
+
+	dialog.ShowError(fmt.Errorf("invalid address or peer ID"), c.w)
+}
+
+// setNickname handles /nick <peer> [name]: it names a peer known by Peer
+// ID or nickname, or without a name removes its nickname.
+func (c *chatApp) setNickname(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields) > 2 {
+		dialog.ShowError(fmt.Errorf("usage: /nick <peer> [name], where name is one word"), c.w)
+		return
+	}
+	p, ok := c.session.FindPeer(fields[0])
+	if !ok {
+		dialog.ShowError(fmt.Errorf("no known peer called %s", fields[0]), c.w)
+		return
+	}
+	nick := ""
+	if len(fields) == 2 {
+		nick = fields[1]
+		if other, ok := c.session.FindPeer(nick); ok && other != p {
+			dialog.ShowError(fmt.Errorf("%s already names another peer", nick), c.w)
+			return
+		}
+	}
+	if err := c.session.SetNickname(p, nick); err != nil {
+		dialog.ShowError(err, c.w)
+		return
+	}
+	c.refreshPeers()
+}
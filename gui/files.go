@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shellchat/p2p"
+	"shellchat/session"
+	"shellchat/storage"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// sendFileTimeout bounds a whole transfer, large files included.
+const sendFileTimeout = 30 * time.Minute
+
+// transferRow is the progress bar of one running transfer.
+type transferRow struct {
+	box   *fyne.Container
+	label *widget.Label
+	bar   *widget.ProgressBar
+}
+
+// pickFile lets the user choose a file for the active conversation.
+func (c *chatApp) pickFile() {
+	if c.activePeer == session.GlobalRoom {
+		dialog.ShowInformation("Send File", "Open a conversation with a peer before sending a file.", c.w)
+		return
+	}
+	peerID := c.activePeer
+	dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, c.w)
+			return
+		}
+		if r == nil {
+			return // cancelled
+		}
+		go c.sendFile(peerID, r)
+	}, c.w)
+}
+
+// sendFile streams the picked file. Pickers on mobile hand out content URIs
+// rather than paths, so those are copied to a temporary file first.
+func (c *chatApp) sendFile(peerID string, r fyne.URIReadCloser) {
+	defer r.Close()
+
+	path := r.URI().Path()
+	if r.URI().Scheme() != "file" {
+		tmpDir, err := os.MkdirTemp("", "shellchat-send")
+		if err != nil {
+			fyne.Do(func() { dialog.ShowError(err, c.w) })
+			return
+		}
+		defer os.RemoveAll(tmpDir)
+		path = filepath.Join(tmpDir, r.URI().Name())
+		if err := copyToFile(path, r); err != nil {
+			fyne.Do(func() { dialog.ShowError(err, c.w) })
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendFileTimeout)
+	defer cancel()
+	offer, err := c.host.SendFile(ctx, peerID, path)
+	if err != nil {
+		logger.Warn("file send failed", "peer", peerID, "err", err)
+		fyne.Do(func() { dialog.ShowError(fmt.Errorf("sending failed: %v", err), c.w) })
+		return
+	}
+
+	if err := c.session.FileSent(peerID, offer); err != nil {
+		logger.Error("failed to save sent file", "err", err)
+	}
+	fyne.Do(func() {
+		if peerID == c.activePeer {
+			c.refreshMessages()
+		}
+	})
+}
+
+func copyToFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// handleFileEvent keeps the progress bars current. Must run on the UI
+// goroutine.
+func (c *chatApp) handleFileEvent(e p2p.FileEvent) {
+	if c.transferBox == nil {
+		return
+	}
+	if e.Offered {
+		c.askOffer(e)
+		return
+	}
+	key := e.Peer + "/" + e.ID
+	row, ok := c.transfers[key]
+
+	if e.Finished {
+		if ok {
+			c.transferBox.Remove(row.box)
+			delete(c.transfers, key)
+		}
+		if e.Incoming && e.Err != nil && !errors.Is(e.Err, p2p.ErrOfferDeclined) {
+			dialog.ShowError(fmt.Errorf("receiving %s failed: %v", e.Name, e.Err), c.w)
+		}
+		if e.Incoming && e.Err == nil {
+			c.refreshPeers()
+			if session.Shows(c.activePeer, e.Peer) {
+				c.refreshMessages()
+			}
+		}
+		return
+	}
+
+	if !ok {
+		arrow := "↑ "
+		if e.Incoming {
+			arrow = "↓ "
+		}
+		row = &transferRow{label: widget.NewLabel(arrow + e.Name), bar: widget.NewProgressBar()}
+		row.box = container.NewVBox(row.label, row.bar)
+		c.transfers[key] = row
+		c.transferBox.Add(row.box)
+	}
+	if e.Size > 0 {
+		row.bar.SetValue(float64(e.Done) / float64(e.Size))
+	}
+}
+
+// askOffer lets the user accept or decline an incoming file.
+func (c *chatApp) askOffer(e p2p.FileEvent) {
+	text := fmt.Sprintf("%s wants to send you %s (%s).\nAccept it?", shortID(e.Peer), e.Name, p2p.FormatSize(e.Size))
+	dialog.ShowConfirm("File Offer", text, func(accept bool) {
+		if err := c.session.AnswerOffer(e, accept); err != nil {
+			dialog.ShowError(err, c.w)
+		}
+	}, c.w)
+}
+
+// saveFile asks where to put a received file and decrypts it there.
+func (c *chatApp) saveFile(file storage.File) {
+	d := dialog.NewFileSave(func(w fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, c.w)
+			return
+		}
+		if w == nil {
+			return // cancelled
+		}
+		defer w.Close()
+		if err := c.session.DecryptFile(file.ID, w); err != nil {
+			dialog.ShowError(err, c.w)
+		}
+	}, c.w)
+	d.SetFileName(file.Name)
+	d.Show()
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"image/color"
-	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"shellchat/commands"
+	"shellchat/config"
+	"shellchat/logging"
+	"shellchat/notify"
 	"shellchat/p2p"
+	"shellchat/session"
 	"shellchat/storage"
+	themes "shellchat/theme"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -20,47 +23,64 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/multiformats/go-multiaddr"
-	"golang.org/x/crypto/argon2"
 )
 
-// Retro Theme Colors
-var (
-	ColorGreen = color.RGBA{0, 255, 0, 255}
-	ColorBlack = color.RGBA{0, 0, 0, 255}
-	ColorGray  = color.RGBA{50, 50, 50, 255}
-)
+var logger = logging.Logger("gui")
 
 type chatApp struct {
-	a    fyne.App
-	w    fyne.Window
-	host *p2p.ChatHost
+	a       fyne.App
+	w       fyne.Window
+	host    *p2p.ChatHost
+	session *session.Session // owns conversations, contacts and sending
+	cfg     *config.Config
+	themes  []themes.Theme
+	theme   string // name of the theme in use
 
 	// UI Components
 	msgList  *widget.List
 	peerList *widget.List
 	msgInput *widget.Entry
 	status   *widget.Label
+	typingLb *widget.Label
+
+	transferBox *fyne.Container
+	transfers   map[string]*transferRow // peer/file id, UI goroutine only
 
 	// Data
 	mu         sync.Mutex
 	activePeer string
-	peers      []string
+	connecting string // peer we are waiting on after /connect
 	messages   []storage.Message
+	focused    bool // the app is in the foreground, UI goroutine only
 }
 
 func main() {
 	a := app.New()
+
+	// Log to the app's own storage; stderr may not exist on mobile.
+	levelName := "info"
+	cfg, err := config.Load()
+	if err == nil && cfg.LogLevel != "" {
+		levelName = cfg.LogLevel
+	}
+	if closer, err := logging.Setup(a.Storage().RootURI().Path(), levelName); err == nil {
+		defer closer.Close()
+	}
+
 	w := a.NewWindow("ShellChat Mobile")
 	w.Resize(fyne.NewSize(400, 700)) // Mobile-ish aspect ratio
 
 	c := &chatApp{
 		a:          a,
 		w:          w,
-		activePeer: "global-room",
-		peers:      []string{"global-room"},
+		activePeer: session.GlobalRoom,
+		transfers:  make(map[string]*transferRow),
+		focused:    true,
+		themes:     loadThemes(),
 	}
+	c.useTheme(cfg.Theme)
+	a.Lifecycle().SetOnEnteredForeground(func() { c.focused = true })
+	a.Lifecycle().SetOnExitedForeground(func() { c.focused = false })
 
 	c.showLogin()
 	w.ShowAndRun()
@@ -76,11 +96,6 @@ func (c *chatApp) showLogin() {
 			return
 		}
 
-		// Init DB
-		salt := []byte("shellchat-static-salt")
-		key := argon2.IDKey([]byte(passEntry.Text), salt, 1, 64*1024, 4, 32)
-		hexKey := fmt.Sprintf("x'%x'", key)
-
 		// Use Fyne's storage path
 		storageDir := c.a.Storage().RootURI().Path()
 		// If path is empty (some platforms), fallback or handle error
@@ -89,17 +104,22 @@ func (c *chatApp) showLogin() {
 			return
 		}
 
-		if err := storage.InitDB(storageDir, hexKey); err != nil {
+		// After /lock the session and host already exist
+		if c.session == nil {
+			c.initP2P()
+		}
+		if err := c.session.Unlock(storageDir, passEntry.Text); err != nil {
+			logger.Warn("unlock failed", "err", err)
 			dialog.ShowError(err, c.w)
 			return
 		}
 
-		c.initP2P()
+		c.loadConversations()
 		c.showChatUI()
 	})
 
 	// Retro Styling
-	logo := canvas.NewText("SHELLCHAT", ColorGreen)
+	logo := canvas.NewText("SHELLCHAT", theme.Color(theme.ColorNamePrimary))
 	logo.TextStyle.Bold = true
 	logo.TextSize = 40
 	logo.Alignment = fyne.TextAlignCenter
@@ -114,49 +134,69 @@ func (c *chatApp) showLogin() {
 	)
 
 	// Background
-	bg := canvas.NewRectangle(ColorBlack)
+	bg := canvas.NewRectangle(theme.Color(theme.ColorNameBackground))
 	c.w.SetContent(container.NewMax(bg, content))
 }
 
 func (c *chatApp) initP2P() {
 	// Initialize Host (Random Port for Mobile)
 	// Note: On Mobile, we might need 0 to let OS choose
-	h, err := p2p.MakeHost(0, nil)
+	cfg, err := config.Load()
 	if err != nil {
-		log.Println("Failed to create host:", err)
+		logger.Warn("using default config", "err", err)
+	}
+
+	c.cfg = cfg
+
+	h, err := p2p.MakeHost(0, nil, cfg.Network)
+	if err != nil {
+		logger.Error("failed to create host", "err", err)
+		c.session = session.New(nil, cfg)
 		return
 	}
 	c.host = h
+	c.session = session.New(h, cfg)
 
 	// Discovery
-	go p2p.SetupDiscovery(h.P2PHost, h.DHT)
+	go p2p.SetupDiscovery(h)
 
 	// Message Listener
 	go func() {
-		for msg := range c.host.MsgChan {
-			parts := strings.SplitN(msg, "|", 2)
-			if len(parts) == 2 {
-				peerID := parts[0]
-				content := parts[1]
-				if storage.DB != nil {
-					storage.SaveMessage(peerID, content, time.Now().Unix(), false)
-				}
-
-				// Update UI if active
-				if peerID == c.activePeer || c.activePeer == "global-room" {
-					c.refreshMessages()
-				}
-				c.addPeer(peerID)
+		for in := range c.host.MsgChan {
+			ev, ok := c.session.Receive(in)
+			if !ok {
+				continue
+			}
+			fyne.Do(func() { c.handleSessionEvent(ev) })
+			if ev.Kind == session.EventTyping {
+				time.AfterFunc(session.TypingTimeout, func() { fyne.Do(c.refreshTyping) })
 			}
 		}
 	}()
 
-	// Peer Discovery Listener (Poll DHT peers)
+	// Peer Events (discovery, connects, disconnects, lookups)
+	sub, err := h.SubscribeEvents()
+	if err != nil {
+		logger.Error("failed to subscribe to peer events", "err", err)
+	} else {
+		go func() {
+			for e := range sub.Out() {
+				switch e := e.(type) {
+				case p2p.PeerEvent:
+					fyne.Do(func() { c.handlePeerEvent(e) })
+				case p2p.FileEvent:
+					c.session.ReceiveFile(e)
+					fyne.Do(func() { c.handleFileEvent(e) })
+				}
+			}
+		}()
+	}
+
+	// Link Status (direct, relayed or offline for the active peer)
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
 		for range ticker.C {
-			// In a real implementation we'd hook into discovery events
-			// Here we just refresh peer list if needed
+			fyne.Do(c.refreshStatus)
 		}
 	}()
 }
@@ -165,24 +205,46 @@ func (c *chatApp) showChatUI() {
 	// Peers List
 	c.peerList = widget.NewList(
 		func() int {
-			c.mu.Lock()
-			defer c.mu.Unlock()
-			return len(c.peers)
+			return len(c.session.Conversations())
 		},
 		func() fyne.CanvasObject { return widget.NewLabel("peer info") },
 		func(id widget.ListItemID, o fyne.CanvasObject) {
-			c.mu.Lock()
-			val := c.peers[id]
-			c.mu.Unlock()
-			o.(*widget.Label).SetText(val)
+			conversations := c.session.Conversations()
+			if id >= len(conversations) {
+				return
+			}
+			val := conversations[id]
+			online := c.session.Online(val)
+			contact := c.session.Contact(val)
+			unread := c.session.Unread(val)
+			if val != session.GlobalRoom {
+				val = presenceDot(contact.Presence, online) + " " + val
+				if online && contact.StatusText != "" {
+					val += " - " + contact.StatusText
+				}
+			}
+			if unread > 0 {
+				val = fmt.Sprintf("(%d) %s", unread, val)
+			}
+			label := o.(*widget.Label)
+			label.TextStyle.Bold = unread > 0
+			label.SetText(val)
 		},
 	)
 	c.peerList.OnSelected = func(id widget.ListItemID) {
-		c.mu.Lock()
-		p := c.peers[id]
-		c.mu.Unlock()
-		c.activePeer = p
+		conversations := c.session.Conversations()
+		if id >= len(conversations) {
+			return
+		}
+		if conversations[id] == c.activePeer {
+			return // reselected by refreshPeers after a re-sort
+		}
+		c.activePeer = conversations[id]
+		c.session.Open(c.activePeer)
+		c.peerList.RefreshItem(id)
 		c.refreshMessages()
+		c.refreshStatus()
+		c.refreshTyping()
 	}
 
 	// Message List using List widget for performance
@@ -193,9 +255,13 @@ func (c *chatApp) showChatUI() {
 			return len(c.messages)
 		},
 		func() fyne.CanvasObject {
+			quote := widget.NewLabel("quote")
+			quote.TextStyle.Italic = true
 			return container.NewVBox(
 				widget.NewLabel("header"),
+				quote,
 				widget.NewLabel("body"),
+				widget.NewLabel("reactions"),
 			)
 		},
 		func(id widget.ListItemID, o fyne.CanvasObject) {
@@ -209,7 +275,9 @@ func (c *chatApp) showChatUI() {
 
 			box := o.(*fyne.Container)
 			header := box.Objects[0].(*widget.Label)
-			body := box.Objects[1].(*widget.Label)
+			quote := box.Objects[1].(*widget.Label)
+			body := box.Objects[2].(*widget.Label)
+			reactions := box.Objects[3].(*widget.Label)
 
 			sender := "THEM"
 			if msg.IsSent {
@@ -223,9 +291,35 @@ func (c *chatApp) showChatUI() {
 
 			ts := time.Unix(msg.Timestamp, 0).Format("15:04")
 			header.SetText(fmt.Sprintf("%s [%s]", sender, ts))
-			body.SetText(msg.Content)
+			body.SetText(messageText(msg))
+			if text := quoteText(msg); text != "" {
+				quote.SetText(text)
+				quote.Alignment = body.Alignment
+				quote.Show()
+			} else {
+				quote.Hide()
+			}
+			if text := reactionsText(msg); text != "" {
+				reactions.SetText(text)
+				reactions.Alignment = body.Alignment
+				reactions.Show()
+			} else {
+				reactions.Hide()
+			}
 		},
 	)
+	c.msgList.OnSelected = func(id widget.ListItemID) {
+		c.msgList.Unselect(id)
+		c.mu.Lock()
+		var file *storage.File
+		if id < len(c.messages) {
+			file = c.messages[id].File
+		}
+		c.mu.Unlock()
+		if file != nil && file.Stored {
+			c.saveFile(*file)
+		}
+	}
 
 	// Input Area
 	c.msgInput = widget.NewEntry()
@@ -237,12 +331,23 @@ func (c *chatApp) showChatUI() {
 		c.sendMessage(text)
 		c.msgInput.SetText("")
 	}
+	c.msgInput.OnChanged = func(text string) {
+		c.session.NotifyTyping(c.activePeer, text)
+	}
 
 	sendBtn := widget.NewButtonWithIcon("", theme.MailSendIcon(), func() {
 		c.msgInput.OnSubmitted(c.msgInput.Text)
 	})
 
-	inputContainer := container.NewBorder(nil, nil, nil, sendBtn, c.msgInput)
+	c.typingLb = widget.NewLabel("")
+	c.typingLb.TextStyle.Italic = true
+	c.typingLb.Hide()
+
+	attachBtn := widget.NewButtonWithIcon("", theme.FileIcon(), c.pickFile)
+
+	c.transferBox = container.NewVBox()
+
+	inputContainer := container.NewBorder(container.NewVBox(c.transferBox, c.typingLb), nil, attachBtn, sendBtn, c.msgInput)
 
 	// Status Bar
 	c.status = widget.NewLabel("Online")
@@ -252,7 +357,7 @@ func (c *chatApp) showChatUI() {
 	// For Mobile, we might want Tabs or just one view. Let's use Split for Tablet/Desktop
 	// and maybe just Chat for small screens? For now, Universal Split.
 
-	chatPanel := container.NewBorder(nil, inputContainer, nil, nil, c.msgList)
+	chatPanel := container.NewBorder(c.status, inputContainer, nil, nil, c.msgList)
 
 	split := container.NewHSplit(c.peerList, chatPanel)
 	split.SetOffset(0.3)
@@ -263,147 +368,217 @@ func (c *chatApp) showChatUI() {
 }
 
 func (c *chatApp) sendMessage(content string) {
-	// handle commands
-	if content == "/myid" {
-		var addrs []string
-		for _, addr := range c.host.P2PHost.Addrs() {
-			addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", addr, c.host.P2PHost.ID()))
-		}
-		fullAddr := strings.Join(addrs, "\n")
-		c.w.Clipboard().SetContent(fullAddr)
-		dialog.ShowInformation("IDs Copied", fullAddr, c.w)
+	if commands.IsCommand(content) {
+		c.runCommand(content)
 		return
 	}
 
-	if content == "/help" {
-		helpText := "Available Commands:\n" +
-			"/myid - Copy your Peer ID\n" +
-			"/connect <addr> - Connect to a peer\n" +
-			"/peers - List connected peers\n" +
-			"/clear - Clear chat history\n" +
-			"/exit - Quit application"
-		dialog.ShowInformation("Help", helpText, c.w)
+	if err := c.session.Send(c.activePeer, "", commands.Unescape(content)); err != nil {
+		logger.Error("failed to save sent message", "err", err)
+		dialog.ShowError(err, c.w)
 		return
 	}
+	c.refreshMessages()
+	c.refreshPeers()
+}
 
-	if content == "/clear" {
-		if err := storage.ClearHistory(); err != nil {
-			dialog.ShowError(err, c.w)
-		} else {
-			c.messages = []storage.Message{}
-			c.msgList.Refresh()
-			dialog.ShowInformation("Chat Cleared", "History deleted locally.", c.w)
-		}
+func (c *chatApp) refreshMessages() {
+	if c.host == nil {
 		return
 	}
+	msgs, err := c.session.Messages(c.activePeer)
+	if err != nil {
+		logger.Error("failed to load history", "peer", c.activePeer, "err", err)
+	}
+
+	c.mu.Lock()
+	c.messages = msgs
+	c.mu.Unlock()
+
+	c.msgList.Refresh()
+	if len(msgs) > 0 {
+		c.msgList.ScrollTo(len(msgs) - 1)
+	}
+}
 
-	if content == "/peers" {
-		var peerList string
-		for _, p := range c.host.P2PHost.Network().Peers() {
-			peerList += p.String() + "\n"
+// handleSessionEvent redraws what an incoming envelope changed. Must run
+// on the UI goroutine.
+func (c *chatApp) handleSessionEvent(ev session.Event) {
+	switch ev.Kind {
+	case session.EventTyping:
+		c.refreshTyping()
+	case session.EventPresence:
+		c.refreshPeers()
+	case session.EventMessage:
+		c.refreshTyping()
+		c.refreshPeers()
+		if session.Shows(c.activePeer, ev.Peer) {
+			c.refreshMessages()
 		}
-		if peerList == "" {
-			peerList = "No peers connected."
+		if n, ok := c.session.Notification(ev, c.focused, time.Now()); ok && c.cfg.Notifications.Method != notify.MethodOff {
+			c.a.SendNotification(fyne.NewNotification(n.Title, n.Body))
+		}
+	case session.EventChanged:
+		if session.Shows(c.activePeer, ev.Peer) {
+			c.refreshMessages()
 		}
-		dialog.ShowInformation("Connected Peers", peerList, c.w)
-		return
 	}
+}
 
-	if content == "/exit" || content == "/quit" {
-		c.a.Quit()
+// refreshPeers redraws the peer list, which is sorted by activity, and
+// keeps the open conversation selected wherever it moved.
+func (c *chatApp) refreshPeers() {
+	if c.peerList == nil {
 		return
 	}
+	c.peerList.Refresh()
+	for i, p := range c.session.Conversations() {
+		if p == c.activePeer {
+			c.peerList.Select(i)
+		}
+	}
+}
 
-	if strings.HasPrefix(content, "/connect ") {
-		addrStr := strings.TrimPrefix(content, "/connect ")
-
-		// 1. Try valid Multiaddr
-		ma, err := multiaddr.NewMultiaddr(addrStr)
-		if err == nil {
-			pi, err := peer.AddrInfoFromP2pAddr(ma)
-			if err == nil {
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				defer cancel()
-				if err := c.host.P2PHost.Connect(ctx, *pi); err != nil {
-					dialog.ShowError(fmt.Errorf("connection failed: %v", err), c.w)
-				} else {
-					c.addPeer(pi.ID.String())
-					dialog.ShowInformation("Connected", "Successfully connected via Multiaddr.", c.w)
-				}
-				return
-			}
+// handlePeerEvent keeps the peer list live. Must run on the UI goroutine.
+func (c *chatApp) handlePeerEvent(e p2p.PeerEvent) {
+	c.session.HandlePeerEvent(e)
+	switch e.Kind {
+	case p2p.EventPeerDiscovered:
+		c.refreshPeers()
+	case p2p.EventPeerConnected, p2p.EventPeerDisconnected:
+		c.refreshPeers()
+		c.refreshStatus()
+		if e.Kind == p2p.EventPeerConnected && e.Peer == c.connecting {
+			c.connecting = ""
+			dialog.ShowInformation("Connected", "Successfully connected to "+e.Peer, c.w)
+		}
+	case p2p.EventConnectFailed:
+		if e.Peer == c.connecting {
+			c.connecting = ""
+			c.refreshStatus()
+			dialog.ShowError(fmt.Errorf("connection failed: %v", e.Err), c.w)
+		}
+	case p2p.EventLookupResult:
+		if e.Peer == c.connecting && e.Err != nil {
+			c.connecting = ""
+			c.refreshStatus()
+			dialog.ShowError(fmt.Errorf("peer not found in DHT: %v", e.Err), c.w)
 		}
+	}
+}
 
-		// 2. Try Peer ID (DHT Lookup)
-		pid, err := peer.Decode(addrStr)
-		if err == nil {
-			dialog.ShowInformation("Searching...", "Looking up peer in DHT...", c.w)
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				defer cancel()
-
-				pi, err := c.host.DHT.FindPeer(ctx, pid)
-				if err != nil {
-					dialog.ShowError(fmt.Errorf("peer not found in DHT: %v", err), c.w)
-					return
-				}
+// loadConversations fills in the presence peers published in earlier
+// sessions and the conversations with unread messages.
+func (c *chatApp) loadConversations() {
+	if err := c.session.LoadContacts(); err != nil {
+		logger.Error("failed to load contacts", "err", err)
+	}
+	if err := c.session.LoadConversations(); err != nil {
+		logger.Error("failed to load conversations", "err", err)
+	}
+}
 
-				if err := c.host.P2PHost.Connect(ctx, pi); err != nil {
-					dialog.ShowError(fmt.Errorf("connection failed: %v", err), c.w)
-				} else {
-					c.addPeer(pi.ID.String())
-					dialog.ShowInformation("Connected", "Successfully connected via Peer ID.", c.w)
-				}
-			}()
-			return
-		}
+// presenceDot marks a contact in the peer list. Peers we are not connected
+// to are offline whatever they last published.
+func presenceDot(state string, online bool) string {
+	if !online {
+		return "○"
+	}
+	switch state {
+	case p2p.PresenceAway:
+		return "◐"
+	case p2p.PresenceDND:
+		return "⊘"
+	}
+	return "●"
+}
 
-		dialog.ShowError(fmt.Errorf("invalid address or peer ID"), c.w)
+// refreshTyping shows who is typing in the active conversation.
+func (c *chatApp) refreshTyping() {
+	if c.typingLb == nil {
 		return
 	}
+	var nicks []string
+	for _, p := range c.session.Typing(c.activePeer) {
+		nicks = append(nicks, shortID(p))
+	}
 
-	// Save locally
-	storage.SaveMessage(c.activePeer, content, time.Now().Unix(), true)
+	switch len(nicks) {
+	case 0:
+		c.typingLb.Hide()
+		return
+	case 1:
+		c.typingLb.SetText(nicks[0] + " is typing…")
+	default:
+		c.typingLb.SetText(strings.Join(nicks, ", ") + " are typing…")
+	}
+	c.typingLb.Show()
+}
 
-	// Send P2P
-	if c.host != nil {
-		for _, p := range c.host.P2PHost.Network().Peers() {
-			go c.host.SendMessage(context.Background(), p.String(), content)
+// messageText is what the message list shows for a stored message.
+func messageText(msg storage.Message) string {
+	if msg.Deleted {
+		return "message deleted"
+	}
+	if msg.File == nil {
+		if msg.EditedAt != 0 {
+			return msg.Content + " (edited)"
 		}
+		return msg.Content
 	}
-
-	c.refreshMessages()
+	text := fmt.Sprintf("📎 %s (%s)", msg.File.Name, p2p.FormatSize(msg.File.Size))
+	if msg.File.Stored {
+		text += " - tap to save"
+	}
+	return text
 }
 
-func (c *chatApp) refreshMessages() {
-	if c.host == nil {
-		return
+// quoteText previews the message msg answers, or returns "".
+func quoteText(msg storage.Message) string {
+	if msg.ReplyTo == "" {
+		return ""
 	}
-	msgs, _ := storage.GetMessages(c.activePeer, 50)
-
-	c.mu.Lock()
-	c.messages = msgs
-	c.mu.Unlock()
-
-	c.msgList.Refresh()
-	if len(msgs) > 0 {
-		c.msgList.ScrollTo(len(msgs) - 1)
+	q := msg.Quote
+	switch {
+	case q == nil:
+		return "┃ original message not available"
+	case q.Content == "":
+		return "┃ message deleted"
+	}
+	author := "THEM"
+	if q.IsSent {
+		author = "YOU"
+	}
+	line, _, _ := strings.Cut(q.Content, "\n")
+	if r := []rune(line); len(r) > 50 {
+		line = string(r[:50]) + "…"
 	}
+	return "┃ " + author + ": " + line
 }
 
-func (c *chatApp) addPeer(p string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// shortID abbreviates a Peer ID for display.
+func shortID(p string) string {
+	if len(p) <= 12 {
+		return p
+	}
+	return p[:12] + "..."
+}
 
-	found := false
-	for _, pine := range c.peers {
-		if pine == p {
-			found = true
-			break
-		}
+func (c *chatApp) refreshStatus() {
+	if c.status == nil || c.host == nil {
+		return
+	}
+	if c.activePeer == session.GlobalRoom {
+		state, _ := c.session.Status()
+		c.status.SetText(fmt.Sprintf("%s | %d peers", strings.ToUpper(state[:1])+state[1:], len(c.host.ChatPeers())))
+		return
 	}
-	if !found {
-		c.peers = append(c.peers, p)
-		c.peerList.Refresh()
+	switch c.host.ConnKind(c.activePeer) {
+	case "direct":
+		c.status.SetText("Online | Direct link")
+	case "relayed":
+		c.status.SetText("Online | Relayed link")
+	default:
+		c.status.SetText("Peer offline")
 	}
 }
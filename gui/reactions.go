@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/session"
+	"shellchat/storage"
+
+	"fyne.io/fyne/v2/dialog"
+)
+
+// react toggles our emoji on the newest message in the conversation.
+func (c *chatApp) react(emoji string) {
+	if !session.ValidReaction(emoji) {
+		dialog.ShowInformation("React", "Usage: /react <emoji>", c.w)
+		return
+	}
+	c.mu.Lock()
+	var target string
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if m := c.messages[i]; m.MsgID != "" && !m.Deleted {
+			target = m.MsgID
+			break
+		}
+	}
+	c.mu.Unlock()
+	if target == "" {
+		dialog.ShowInformation("React", "Nothing to react to.", c.w)
+		return
+	}
+
+	if _, err := c.session.React(target, emoji); err != nil {
+		logger.Error("failed to save reaction", "err", err)
+		dialog.ShowError(err, c.w)
+		return
+	}
+	c.refreshMessages()
+}
+
+// reactionsText lists the reaction counts under a message, or returns "".
+// Our own reactions are bracketed.
+func reactionsText(msg storage.Message) string {
+	parts := make([]string, 0, len(msg.Reactions))
+	for _, r := range msg.Reactions {
+		part := fmt.Sprintf("%s %d", r.Emoji, r.Count)
+		if r.Mine {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "  ")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+
+	"shellchat/config"
+	themes "shellchat/theme"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+)
+
+// paletteTheme is a fyne.Theme with the colors of a shellchat theme, so the
+// GUI matches the TUI. Fonts, icons and sizes are Fyne's own.
+type paletteTheme struct {
+	t themes.Theme
+}
+
+var _ fyne.Theme = paletteTheme{}
+
+func (p paletteTheme) Color(name fyne.ThemeColorName, _ fyne.ThemeVariant) color.Color {
+	switch name {
+	case theme.ColorNameBackground, theme.ColorNameInputBackground,
+		theme.ColorNameMenuBackground, theme.ColorNameOverlayBackground,
+		theme.ColorNameHeaderBackground, theme.ColorNameForegroundOnPrimary:
+		return rgb(p.t.Background)
+	case theme.ColorNameForeground, theme.ColorNamePrimary:
+		return rgb(p.t.Foreground)
+	case theme.ColorNameFocus, theme.ColorNameHyperlink:
+		return rgb(p.t.Accent)
+	case theme.ColorNameSelection:
+		return withAlpha(rgb(p.t.Accent), 0x40)
+	case theme.ColorNameHover:
+		return withAlpha(rgb(p.t.Foreground), 0x20)
+	case theme.ColorNamePressed:
+		return withAlpha(rgb(p.t.Foreground), 0x40)
+	case theme.ColorNameDisabled, theme.ColorNamePlaceHolder, theme.ColorNameSeparator,
+		theme.ColorNameInputBorder, theme.ColorNameScrollBar:
+		return rgb(p.t.Muted)
+	case theme.ColorNameError:
+		return rgb(p.t.Error)
+	}
+	return theme.DefaultTheme().Color(name, p.variant())
+}
+
+// variant picks Fyne's light or dark colors for everything the palette
+// does not cover.
+func (p paletteTheme) variant() fyne.ThemeVariant {
+	if p.t.Light {
+		return theme.VariantLight
+	}
+	return theme.VariantDark
+}
+
+func (p paletteTheme) Font(style fyne.TextStyle) fyne.Resource {
+	return theme.DefaultTheme().Font(style)
+}
+
+func (p paletteTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
+	return theme.DefaultTheme().Icon(name)
+}
+
+func (p paletteTheme) Size(name fyne.ThemeSizeName) float32 {
+	return theme.DefaultTheme().Size(name)
+}
+
+// rgb converts a theme color; themes are validated when they are loaded.
+func rgb(c string) color.NRGBA {
+	v, _ := themes.RGB(c)
+	return v
+}
+
+func withAlpha(c color.NRGBA, a uint8) color.NRGBA {
+	c.A = a
+	return c
+}
+
+// loadThemes returns the built-in themes and those in the data directory.
+func loadThemes() []themes.Theme {
+	dir, err := config.Dir()
+	if err != nil {
+		logger.Warn("no config directory, using built-in themes", "err", err)
+		return themes.Builtin()
+	}
+	list, err := themes.Load(dir)
+	if err != nil {
+		logger.Warn("failed to load some themes", "err", err)
+	}
+	return list
+}
+
+// useTheme applies the theme called name to the whole app, or the default
+// if there is none.
+func (c *chatApp) useTheme(name string) {
+	t, ok := themes.Find(c.themes, name)
+	if !ok {
+		if name != "" {
+			logger.Warn("unknown theme, using the default", "theme", name)
+		}
+		t, _ = themes.Find(c.themes, themes.Default)
+	}
+	c.a.Settings().SetTheme(paletteTheme{t})
+	c.theme = t.Name
+}
+
+// switchTheme handles /theme.
+func (c *chatApp) switchTheme(arg string) {
+	available := "Available: " + strings.Join(themes.Names(c.themes), ", ")
+	if arg == "" {
+		dialog.ShowInformation("Theme", fmt.Sprintf("Theme: %s\n%s", c.theme, available), c.w)
+		return
+	}
+	if _, ok := themes.Find(c.themes, arg); !ok {
+		dialog.ShowError(fmt.Errorf("unknown theme %q. %s", arg, available), c.w)
+		return
+	}
+	c.useTheme(arg)
+}
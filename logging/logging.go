@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	golog "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/gologshim"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const fileName = "shellchat.log"
+
+var level = new(slog.LevelVar)
+
+// Setup sends all logging to a rotating file in dir, at the given level
+// (debug, info, warn or error). It installs the handler as the slog default
+// and routes libp2p's own loggers into the same file, so nothing is printed
+// over the TUI. Recent records are also kept in memory for the /logs pane.
+func Setup(dir, levelName string) (io.Closer, error) {
+	lvl, err := ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	level.Set(lvl)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file := &lumberjack.Logger{
+		Filename:   filepath.Join(dir, fileName),
+		MaxSize:    5, // MB
+		MaxBackups: 3,
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	handler := &teeHandler{handlers: []slog.Handler{
+		slog.NewJSONHandler(file, opts),
+		slog.NewTextHandler(recent, opts),
+	}}
+	slog.SetDefault(slog.New(handler))
+
+	// go-libp2p logs through slog; the DHT and other IPFS packages still use
+	// go-log's zap core.
+	gologshim.SetDefaultHandler(handler)
+	golog.SetPrimaryCore(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(file),
+		zapcore.DebugLevel,
+	))
+	golog.SetAllLoggers(golog.LogLevel(zapLevel(lvl)))
+
+	return file, nil
+}
+
+// ParseLevel converts a --log-level value to a slog.Level.
+func ParseLevel(name string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToUpper(name))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", name)
+	}
+	return lvl, nil
+}
+
+func zapLevel(lvl slog.Level) zapcore.Level {
+	switch {
+	case lvl <= slog.LevelDebug:
+		return zapcore.DebugLevel
+	case lvl <= slog.LevelInfo:
+		return zapcore.InfoLevel
+	case lvl <= slog.LevelWarn:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
+
+// Logger returns a logger tagged with component. It resolves the default
+// handler on every call, so package level loggers created before Setup
+// still end up in the log file.
+func Logger(component string) *slog.Logger {
+	return slog.New(&lazyHandler{attrs: []slog.Attr{slog.String("component", component)}})
+}
+
+type lazyHandler struct {
+	attrs []slog.Attr
+}
+
+func (h *lazyHandler) handler() slog.Handler {
+	return slog.Default().Handler().WithAttrs(h.attrs)
+}
+
+func (h *lazyHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, lvl)
+}
+
+func (h *lazyHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *lazyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lazyHandler{attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *lazyHandler) WithGroup(name string) slog.Handler {
+	return h.handler().WithGroup(name)
+}
+
+// teeHandler fans records out to several handlers.
+type teeHandler struct {
+	handlers []slog.Handler
+}
+
+func (t *teeHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	for _, h := range t.handlers {
+		if h.Enabled(ctx, lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range t.handlers {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make([]slog.Handler, len(t.handlers))
+	for i, h := range t.handlers {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return &teeHandler{handlers: hs}
+}
+
+func (t *teeHandler) WithGroup(name string) slog.Handler {
+	hs := make([]slog.Handler, len(t.handlers))
+	for i, h := range t.handlers {
+		hs[i] = h.WithGroup(name)
+	}
+	return &teeHandler{handlers: hs}
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+)
+
+const recentSize = 500
+
+// recent keeps the last log lines in memory for the TUI's /logs pane.
+var recent = &ring{}
+
+type ring struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+	seq   uint64
+}
+
+// Write stores one formatted record. slog handlers write a whole record
+// per call, so each call is one line.
+func (r *ring) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lines == nil {
+		r.lines = make([]string, recentSize)
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % recentSize
+	if r.next == 0 {
+		r.full = true
+	}
+	r.seq++
+	return len(p), nil
+}
+
+// Recent returns up to n of the most recent log lines, oldest first, and a
+// sequence number that changes whenever a new line is logged.
+func Recent(n int) ([]string, uint64) {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+
+	count := recent.next
+	if recent.full {
+		count = recentSize
+	}
+	if n > count {
+		n = count
+	}
+
+	lines := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		lines = append(lines, recent.lines[(recent.next-i+recentSize)%recentSize])
+	}
+	return lines, recent.seq
+}
@@ -0,0 +1,17 @@
+package notify
+
+import "github.com/godbus/dbus/v5"
+
+// DBus shows a desktop notification through the session bus, like
+// notify-send does.
+func DBus(title, body string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"ShellChat", uint32(0), "", clean(title), clean(body),
+		[]string{}, map[string]dbus.Variant{}, int32(-1))
+	return call.Err
+}
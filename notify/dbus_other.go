@@ -0,0 +1,8 @@
+//go:build !linux
+
+package notify
+
+// DBus is only available on Linux.
+func DBus(title, body string) error {
+	return ErrUnsupported
+}
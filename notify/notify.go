@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Methods accepted in notifications.method.
+const (
+	MethodOff    = "off"
+	MethodBell   = "bell"
+	MethodOSC9   = "osc9"   // iTerm2, WezTerm, Windows Terminal
+	MethodOSC777 = "osc777" // urxvt, foot, Ghostty, VTE based terminals
+	MethodDBus   = "dbus"   // org.freedesktop.Notifications
+)
+
+// ErrUnsupported is returned by DBus where there is no session bus.
+var ErrUnsupported = errors.New("desktop notifications are not supported on this platform")
+
+// Valid reports whether method is one of the known methods.
+func Valid(method string) bool {
+	switch method {
+	case MethodOff, MethodBell, MethodOSC9, MethodOSC777, MethodDBus:
+		return true
+	}
+	return false
+}
+
+// Terminal returns the escape sequence that shows a notification by
+// method, or "" for methods that don't go through the terminal. Peers
+// choose the text, so control characters are removed before it is put in
+// the sequence.
+func Terminal(method, title, body string) string {
+	switch method {
+	case MethodBell:
+		return "\a"
+	case MethodOSC9:
+		return "\x1b]9;" + clean(title+": "+body) + "\a"
+	case MethodOSC777:
+		// Fields are separated by semicolons
+		title = strings.ReplaceAll(clean(title), ";", ",")
+		body = strings.ReplaceAll(clean(body), ";", ",")
+		return "\x1b]777;notify;" + title + ";" + body + "\a"
+	}
+	return ""
+}
+
+// clean flattens s to one line of printable characters.
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,20 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerminalStripsControlCharacters(t *testing.T) {
+	// A peer must not be able to end the sequence early and inject its own
+	seq := Terminal(MethodOSC777, "peer", "hi\x07\x1b]0;pwned\x07;x")
+	if strings.Count(seq, "\x07") != 1 || strings.Count(seq, "\x1b") != 1 {
+		t.Errorf("Terminal = %q, want one sequence", seq)
+	}
+	if !strings.HasSuffix(seq, ";hi]0,pwned,x\x07") {
+		t.Errorf("Terminal = %q", seq)
+	}
+	if seq := Terminal(MethodDBus, "peer", "hi"); seq != "" {
+		t.Errorf("Terminal(dbus) = %q, want none", seq)
+	}
+}
@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Transport names used to group addresses, in display order.
+var transportOrder = []string{"tcp", "quic", "webtransport", "webrtc", "websocket", "relay", "other"}
+
+// DefaultListenAddrs returns TCP and QUIC-v1 listeners on IPv4 and IPv6,
+// plus WebTransport when enabled. Port 0 lets the OS pick.
+func DefaultListenAddrs(port int, webTransport bool) []string {
+	addrs := []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port),
+		fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", port),
+		fmt.Sprintf("/ip6/::/tcp/%d", port),
+		fmt.Sprintf("/ip6/::/udp/%d/quic-v1", port),
+	}
+	if webTransport {
+		addrs = append(addrs,
+			fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1/webtransport", port),
+			fmt.Sprintf("/ip6/::/udp/%d/quic-v1/webtransport", port),
+		)
+	}
+	return addrs
+}
+
+// TransportName classifies addr by its outermost transport protocol.
+func TransportName(addr multiaddr.Multiaddr) string {
+	has := func(code int) bool {
+		_, err := addr.ValueForProtocol(code)
+		return err == nil
+	}
+	switch {
+	case has(multiaddr.P_CIRCUIT):
+		return "relay"
+	case has(multiaddr.P_WEBTRANSPORT):
+		return "webtransport"
+	case has(multiaddr.P_WEBRTC_DIRECT):
+		return "webrtc"
+	case has(multiaddr.P_QUIC_V1):
+		return "quic"
+	case has(multiaddr.P_WS), has(multiaddr.P_WSS):
+		return "websocket"
+	case has(multiaddr.P_TCP):
+		return "tcp"
+	}
+	return "other"
+}
+
+// AddrGroup is a set of addresses sharing a transport.
+type AddrGroup struct {
+	Transport string   `json:"transport"`
+	Addrs     []string `json:"addrs"`
+}
+
+// GroupAddrs groups addrs by transport in a stable order, appending
+// suffix (e.g. "/p2p/<id>") to every address.
+func GroupAddrs(addrs []multiaddr.Multiaddr, suffix string) []AddrGroup {
+	byTransport := make(map[string][]string)
+	for _, addr := range addrs {
+		t := TransportName(addr)
+		byTransport[t] = append(byTransport[t], addr.String()+suffix)
+	}
+
+	var groups []AddrGroup
+	for _, t := range transportOrder {
+		if len(byTransport[t]) > 0 {
+			groups = append(groups, AddrGroup{Transport: t, Addrs: byTransport[t]})
+		}
+	}
+	return groups
+}
+
+// AddrGroups returns our advertised addresses, with Peer ID, grouped by transport.
+func (ch *ChatHost) AddrGroups() []AddrGroup {
+	return GroupAddrs(ch.P2PHost.Addrs(), "/p2p/"+ch.P2PHost.ID().String())
+}
+
+// FullAddrs returns our advertised addresses with the Peer ID appended.
+func (ch *ChatHost) FullAddrs() []string {
+	var addrs []string
+	for _, g := range ch.AddrGroups() {
+		addrs = append(addrs, g.Addrs...)
+	}
+	return addrs
+}
+
+// FormatAddrGroups renders groups as a human readable, indented list.
+func FormatAddrGroups(groups []AddrGroup) string {
+	var sb strings.Builder
+	for _, g := range groups {
+		sb.WriteString(strings.ToUpper(g.Transport) + ":\n")
+		for _, addr := range g.Addrs {
+			sb.WriteString("  " + addr + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,249 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// TransportStatus summarises one transport for `shellchat doctor`.
+type TransportStatus struct {
+	Transport   string   `json:"transport"`
+	Status      string   `json:"status"` // reachable, unreachable or unknown
+	Listening   []string `json:"listening"`
+	Reachable   []string `json:"reachable,omitempty"`
+	Unreachable []string `json:"unreachable,omitempty"`
+}
+
+// PortMapping is a UPnP / NAT-PMP mapping made for one of our listeners.
+type PortMapping struct {
+	Listen   string `json:"listen"`
+	External string `json:"external"`
+}
+
+// NATStatus describes what the NAT manager found on the local network.
+type NATStatus struct {
+	DeviceFound bool              `json:"device_found"`
+	Mappings    []PortMapping     `json:"mappings,omitempty"`
+	DeviceTypes map[string]string `json:"device_types,omitempty"` // per transport protocol
+}
+
+// MDNSStatus describes local network discovery.
+type MDNSStatus struct {
+	Running    bool   `json:"running"`
+	Error      string `json:"error,omitempty"`
+	PeersFound int    `json:"peers_found"`
+}
+
+// Diagnostics is a snapshot of the node's network health.
+type Diagnostics struct {
+	PeerID             string            `json:"peer_id"`
+	Bootstrap          []BootstrapResult `json:"bootstrap"`
+	BootstrapConnected int               `json:"bootstrap_connected"`
+	BootstrapPending   bool              `json:"bootstrap_pending,omitempty"`
+	RoutingTableSize   int               `json:"routing_table_size"`
+	Reachability       string            `json:"reachability"` // public, private or unknown
+	NAT                NATStatus         `json:"nat"`
+	MDNS               MDNSStatus        `json:"mdns"`
+	ObservedAddrs      []string          `json:"observed_addrs"`
+	Transports         []TransportStatus `json:"transports"`
+	DiscoveryError     string            `json:"discovery_error,omitempty"`
+	Hints              []string          `json:"hints,omitempty"`
+}
+
+// Diagnose watches the node until ctx is done and reports what it learned.
+// Give it enough time (30s or so) for bootstrap to finish and AutoNAT to
+// probe our addresses.
+func (ch *ChatHost) Diagnose(ctx context.Context) (*Diagnostics, error) {
+	sub, err := ch.P2PHost.EventBus().Subscribe([]interface{}{
+		new(event.EvtHostReachableAddrsChanged),
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtNATDeviceTypeChanged),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	var reach event.EvtHostReachableAddrsChanged
+	reachability := network.ReachabilityUnknown
+	deviceTypes := make(map[string]string)
+	for done := false; !done; {
+		select {
+		case e := <-sub.Out():
+			switch e := e.(type) {
+			case event.EvtHostReachableAddrsChanged:
+				reach = e
+			case event.EvtLocalReachabilityChanged:
+				reachability = e.Reachability
+			case event.EvtNATDeviceTypeChanged:
+				deviceTypes[e.TransportProtocol.String()] = e.NatDeviceType.String()
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	d := &Diagnostics{
+		PeerID:           ch.P2PHost.ID().String(),
+		RoutingTableSize: ch.DHT.RoutingTable().Size(),
+		Reachability:     reachabilityName(reachability),
+		ObservedAddrs:    []string{},
+		Transports:       transportStatuses(ch.P2PHost.Network().ListenAddresses(), reach),
+	}
+
+	// Bootstrap may still be dialing if ctx was short; report what we have.
+	select {
+	case <-ch.bootstrapDone:
+	default:
+		d.BootstrapPending = true
+	}
+
+	ch.mu.Lock()
+	d.Bootstrap = append([]BootstrapResult(nil), ch.bootstrap...)
+	d.MDNS = MDNSStatus{Running: ch.mdnsRunning, PeersFound: len(ch.mdnsPeers)}
+	if ch.mdnsErr != nil {
+		d.MDNS.Error = ch.mdnsErr.Error()
+	}
+	if ch.discoveryErr != nil {
+		d.DiscoveryError = ch.discoveryErr.Error()
+	}
+	ch.mu.Unlock()
+
+	for _, b := range d.Bootstrap {
+		if b.Error == "" {
+			d.BootstrapConnected++
+		}
+	}
+
+	if ch.natMgr != nil && ch.natMgr.HasDiscoveredNAT() {
+		d.NAT.DeviceFound = true
+		for _, addr := range ch.P2PHost.Network().ListenAddresses() {
+			if mapped := ch.natMgr.GetMapping(addr); mapped != nil {
+				d.NAT.Mappings = append(d.NAT.Mappings, PortMapping{Listen: addr.String(), External: mapped.String()})
+			}
+		}
+	}
+	if len(deviceTypes) > 0 {
+		d.NAT.DeviceTypes = deviceTypes
+	}
+
+	d.ObservedAddrs = append(d.ObservedAddrs, ch.observedAddrs()...)
+	d.Hints = d.hints()
+	return d, nil
+}
+
+// observedAddrs returns public addresses that peers report seeing us on,
+// i.e. our direct addresses that are not bound to a local interface.
+func (ch *ChatHost) observedAddrs() []string {
+	all, ok := ch.P2PHost.(interface{ AllAddrs() []multiaddr.Multiaddr })
+	if !ok {
+		return nil
+	}
+	local := make(map[string]bool)
+	if ifaceAddrs, err := ch.P2PHost.Network().InterfaceListenAddresses(); err == nil {
+		for _, addr := range ifaceAddrs {
+			local[addr.String()] = true
+		}
+	}
+
+	var observed []string
+	for _, addr := range all.AllAddrs() {
+		if !local[addr.String()] && manet.IsPublicAddr(addr) {
+			observed = append(observed, addr.String())
+		}
+	}
+	return observed
+}
+
+func (d *Diagnostics) hints() []string {
+	var hints []string
+	if len(d.Bootstrap) > 0 && d.BootstrapConnected == 0 && !d.BootstrapPending {
+		hints = append(hints, "Could not reach any bootstrap peer. Check your internet connection and firewall, "+
+			"or set network.bootstrap_peers to nodes you run yourself (`shellchat relay`).")
+	}
+	if d.RoutingTableSize == 0 {
+		hints = append(hints, "The DHT routing table is empty, so Peer ID lookups (/connect <peer-id>) will fail. "+
+			"Use a full multiaddr instead, or wait longer for bootstrap.")
+	}
+	switch d.Reachability {
+	case "private":
+		if len(d.NAT.Mappings) == 0 {
+			hints = append(hints, "You are behind a NAT with no port mapping. Enable UPnP / NAT-PMP on your router "+
+				"or forward a port and pin it with network.listen_addrs.")
+		}
+		for proto, t := range d.NAT.DeviceTypes {
+			if t == network.NATDeviceTypeEndpointDependent.String() {
+				hints = append(hints, fmt.Sprintf("Your NAT is symmetric for %s, so hole punching is unlikely to work. "+
+					"Configure network.static_relays so peers can reach you via a relay.", proto))
+			}
+		}
+	case "unknown":
+		hints = append(hints, "AutoNAT could not determine reachability yet. Run again with a longer --wait.")
+	}
+	if d.MDNS.Error != "" {
+		hints = append(hints, "mDNS failed to start, so peers on your LAN will not be found automatically. "+
+			"Check that multicast is allowed on this interface.")
+	}
+	reachable := false
+	for _, t := range d.Transports {
+		if t.Status == "reachable" {
+			reachable = true
+		}
+	}
+	if !reachable && d.Reachability != "public" {
+		hints = append(hints, "No transport was confirmed reachable from the internet. "+
+			"Others can only reach you through a relay or when you dial them first.")
+	}
+	return hints
+}
+
+func reachabilityName(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return "public"
+	case network.ReachabilityPrivate:
+		return "private"
+	}
+	return "unknown"
+}
+
+func transportStatuses(listening []multiaddr.Multiaddr, reach event.EvtHostReachableAddrsChanged) []TransportStatus {
+	byTransport := make(map[string]*TransportStatus)
+	get := func(addr multiaddr.Multiaddr) *TransportStatus {
+		t := TransportName(addr)
+		if byTransport[t] == nil {
+			byTransport[t] = &TransportStatus{Transport: t, Status: "unknown"}
+		}
+		return byTransport[t]
+	}
+
+	for _, addr := range listening {
+		ts := get(addr)
+		ts.Listening = append(ts.Listening, addr.String())
+	}
+	for _, addr := range reach.Unreachable {
+		ts := get(addr)
+		ts.Unreachable = append(ts.Unreachable, addr.String())
+		if ts.Status == "unknown" {
+			ts.Status = "unreachable"
+		}
+	}
+	for _, addr := range reach.Reachable {
+		ts := get(addr)
+		ts.Reachable = append(ts.Reachable, addr.String())
+		ts.Status = "reachable"
+	}
+
+	var statuses []TransportStatus
+	for _, t := range transportOrder {
+		if ts := byTransport[t]; ts != nil {
+			statuses = append(statuses, *ts)
+		}
+	}
+	return statuses
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"time"
 
-	dht "github.com/libp2p/go-libp2p-kad-dht"
-	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
@@ -14,35 +12,52 @@ import (
 )
 
 type discoveryNotifee struct {
-	h host.Host
+	ch *ChatHost
 }
 
 func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
-	if pi.ID == n.h.ID() {
+	h := n.ch.P2PHost
+	if pi.ID == h.ID() {
 		return
 	}
-	if n.h.Network().Connectedness(pi.ID) != network.Connected {
+	n.ch.mu.Lock()
+	n.ch.mdnsPeers[pi.ID] = true
+	n.ch.mu.Unlock()
+	n.ch.emit(PeerEvent{Kind: EventPeerDiscovered, Peer: pi.ID.String(), Source: "mdns"})
+
+	if h.Network().Connectedness(pi.ID) != network.Connected {
 		// New connection
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		if err := n.h.Connect(ctx, pi); err == nil {
-			// fmt.Printf("Connected to peer: %s\n", pi.ID.ShortString())
+		if err := n.ch.Connect(ctx, pi, "mdns"); err != nil {
+			n.ch.setDiscoveryErr(err)
+		} else {
+			log.Info("connected to local peer", "peer", pi.ID)
 		}
 	}
 }
 
-func SetupDiscovery(h host.Host, dht *dht.IpfsDHT) error {
+func SetupDiscovery(ch *ChatHost) error {
+	h := ch.P2PHost
+
 	// 1. mDNS (Local)
 	serviceTag := "shellchat-mdns"
-	n := &discoveryNotifee{h: h}
+	n := &discoveryNotifee{ch: ch}
 	s := mdns.NewMdnsService(h, serviceTag, n)
 	if err := s.Start(); err != nil {
+		log.Warn("mDNS failed to start", "err", err)
+		ch.mu.Lock()
+		ch.mdnsErr = err
+		ch.mu.Unlock()
 		return err
 	}
+	ch.mu.Lock()
+	ch.mdnsRunning = true
+	ch.mu.Unlock()
 
 	// 2. DHT (Global)
 	// Advertise our service on the DHT
-	routingDiscovery := routing.NewRoutingDiscovery(dht)
+	routingDiscovery := routing.NewRoutingDiscovery(ch.DHT)
 	util.Advertise(context.Background(), routingDiscovery, "shellchat-global")
 
 	// Look for peers
@@ -50,6 +65,7 @@ func SetupDiscovery(h host.Host, dht *dht.IpfsDHT) error {
 		for {
 			peerChan, err := routingDiscovery.FindPeers(context.Background(), "shellchat-global")
 			if err != nil {
+				ch.setDiscoveryErr(err)
 				time.Sleep(time.Minute)
 				continue
 			}
@@ -57,10 +73,11 @@ func SetupDiscovery(h host.Host, dht *dht.IpfsDHT) error {
 				if peer.ID == h.ID() {
 					continue
 				}
+				ch.emit(PeerEvent{Kind: EventPeerDiscovered, Peer: peer.ID.String(), Source: "dht"})
 				if h.Network().Connectedness(peer.ID) != network.Connected {
 					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-					if err := h.Connect(ctx, peer); err == nil {
-						// Found global peer
+					if err := ch.Connect(ctx, peer, "dht"); err != nil {
+						ch.setDiscoveryErr(err)
 					}
 					cancel()
 				}
@@ -71,3 +88,10 @@ func SetupDiscovery(h host.Host, dht *dht.IpfsDHT) error {
 
 	return nil
 }
+
+func (ch *ChatHost) setDiscoveryErr(err error) {
+	log.Debug("discovery failed", "err", err)
+	ch.mu.Lock()
+	ch.discoveryErr = err
+	ch.mu.Unlock()
+}
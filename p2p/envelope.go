@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Envelope types carried on the chat protocol.
+const (
+	EnvMessage  = "msg"      // a chat message, persisted by the receiver
+	EnvTyping   = "typing"   // ephemeral, never persisted
+	EnvPresence = "presence" // the sender's presence state and status text
+	EnvEdit     = "edit"     // replaces the body of the sender's message Target
+	EnvDelete   = "delete"   // retracts the sender's message Target
+	EnvReaction = "reaction" // adds, or with Remove takes back, emoji Body on Target
+)
+
+// TypingInterval is the minimum gap between typing envelopes to one peer.
+// Receivers should expire an indicator after about twice this long.
+const TypingInterval = 3 * time.Second
+
+// MaxMessageLen caps the text of a chat message, in characters.
+const MaxMessageLen = 16000
+
+// maxFrameSize caps one newline-delimited frame: a message of MaxMessageLen
+// characters with every one escaped by JSON, plus the other fields.
+const maxFrameSize = 6*MaxMessageLen + 4096
+
+// errFrameTooLarge is returned for a frame over maxFrameSize. The stream
+// is reset without delivering it.
+var errFrameTooLarge = errors.New("frame too large")
+
+// Envelope is one newline-delimited JSON frame on a chat stream.
+type Envelope struct {
+	Type string `json:"type"`
+	Body string `json:"body,omitempty"`
+	// ID identifies a message across peers; Target refers to one.
+	ID     string `json:"id,omitempty"`
+	Target string `json:"target,omitempty"`
+	// ReplyTo is the ID of the message a chat message answers.
+	ReplyTo string `json:"reply_to,omitempty"`
+	Remove  bool   `json:"remove,omitempty"`
+
+	// Presence fields
+	Presence string `json:"presence,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// Incoming is an envelope received from Peer.
+type Incoming struct {
+	Peer string
+	Envelope
+}
+
+// readEnvelopes delivers the frames read from r until it fails. Peers that
+// predate envelopes write raw text without newlines; whatever such a peer has
+// sent so far is delivered as a single message.
+func (ch *ChatHost) readEnvelopes(peerID string, r *bufio.Reader) error {
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if first[0] != '{' {
+			raw := make([]byte, r.Buffered())
+			n, _ := r.Read(raw)
+			ch.MsgChan <- Incoming{Peer: peerID, Envelope: Envelope{Type: EnvMessage, Body: string(raw[:n])}}
+			continue
+		}
+
+		line, err := readLine(r)
+		if err == errFrameTooLarge {
+			return err
+		}
+		if len(line) > 0 {
+			var env Envelope
+			if jsonErr := json.Unmarshal(line, &env); jsonErr != nil || env.Type == "" {
+				env = Envelope{Type: EnvMessage, Body: string(line)}
+			}
+			ch.MsgChan <- Incoming{Peer: peerID, Envelope: env}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads up to and including the next newline, like ReadBytes,
+// but gives up with errFrameTooLarge once the line passes maxFrameSize.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxFrameSize {
+			return nil, errFrameTooLarge
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// NewMessageID returns a random ID for an outgoing message.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func encodeEnvelope(env Envelope) ([]byte, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// typingThrottle remembers when we last told each peer we are typing.
+type typingThrottle struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (t *typingThrottle) allow(peerID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = make(map[string]time.Time)
+	}
+	if time.Since(t.last[peerID]) < TypingInterval {
+		return false
+	}
+	t.last[peerID] = time.Now()
+	return true
+}
+
+// NotifyTyping tells peers that we are typing, at most once per
+// TypingInterval each. It returns immediately; failures are only logged.
+func (ch *ChatHost) NotifyTyping(peers ...string) {
+	for _, p := range peers {
+		if !ch.typing.allow(p) {
+			continue
+		}
+		go func(pid string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := ch.Send(ctx, pid, Envelope{Type: EnvTyping}); err != nil {
+				log.Debug("typing notification failed", "peer", pid, "err", err)
+			}
+		}(p)
+	}
+}
@@ -0,0 +1,36 @@
+package p2p
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvelopesDropsOversizedFrames(t *testing.T) {
+	t.Parallel()
+	ch := &ChatHost{MsgChan: make(chan Incoming, 4)}
+	huge := `{"type":"msg","body":"` + strings.Repeat("a", maxFrameSize) + `"}` + "\n"
+	r := bufio.NewReader(strings.NewReader(`{"type":"msg","body":"hi"}` + "\n" + huge))
+
+	if err := ch.readEnvelopes("peerA", r); err != errFrameTooLarge {
+		t.Fatalf("readEnvelopes = %v, want errFrameTooLarge", err)
+	}
+	close(ch.MsgChan)
+	var got []string
+	for in := range ch.MsgChan {
+		got = append(got, in.Body)
+	}
+	if len(got) != 1 || got[0] != "hi" {
+		t.Errorf("delivered %d frames, want only the small one", len(got))
+	}
+}
+
+func TestReadLineKeepsLastFrame(t *testing.T) {
+	t.Parallel()
+	r := bufio.NewReader(strings.NewReader(`{"offset":3}`))
+	line, err := readLine(r)
+	if err != io.EOF || string(line) != `{"offset":3}` {
+		t.Errorf("readLine = %q, %v", line, err)
+	}
+}
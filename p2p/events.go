@@ -0,0 +1,118 @@
+package p2p
+
+import (
+	"context"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// EventKind identifies what happened in a PeerEvent.
+type EventKind int
+
+const (
+	// EventPeerDiscovered: a ShellChat peer was found via mDNS or the DHT.
+	EventPeerDiscovered EventKind = iota
+	// EventPeerConnected: a peer speaking our chat protocol is connected.
+	EventPeerConnected
+	// EventPeerDisconnected: the last connection to a peer closed.
+	EventPeerDisconnected
+	// EventConnectFailed: dialing a peer failed.
+	EventConnectFailed
+	// EventLookupResult: a DHT peer lookup finished, successfully or not.
+	EventLookupResult
+)
+
+// PeerEvent is published on the host's libp2p event bus whenever the state
+// of a peer changes, so the front-ends can keep their contact lists live.
+type PeerEvent struct {
+	Kind   EventKind
+	Peer   string
+	Source string   // "mdns", "dht" or "user" for discoveries and dials
+	Addrs  []string // addresses found by a lookup
+	Err    error
+}
+
+// SubscribeEvents returns a subscription delivering PeerEvent and FileEvent
+// values. Callers must Close it when done.
+func (ch *ChatHost) SubscribeEvents() (event.Subscription, error) {
+	return ch.P2PHost.EventBus().Subscribe([]interface{}{new(PeerEvent), new(FileEvent)})
+}
+
+func (ch *ChatHost) emit(e PeerEvent) {
+	if ch.emitter == nil {
+		return
+	}
+	if err := ch.emitter.Emit(e); err != nil {
+		log.Debug("failed to emit peer event", "err", err)
+	}
+}
+
+// Connect dials pi and publishes EventConnectFailed if that does not work.
+// Success is reported as EventPeerConnected once the peer is identified.
+func (ch *ChatHost) Connect(ctx context.Context, pi peer.AddrInfo, source string) error {
+	if err := ch.P2PHost.Connect(ctx, pi); err != nil {
+		ch.emit(PeerEvent{Kind: EventConnectFailed, Peer: pi.ID.String(), Source: source, Err: err})
+		return err
+	}
+	return nil
+}
+
+// FindPeer looks pid up in the DHT and publishes the result.
+func (ch *ChatHost) FindPeer(ctx context.Context, pid peer.ID) (peer.AddrInfo, error) {
+	pi, err := ch.DHT.FindPeer(ctx, pid)
+	e := PeerEvent{Kind: EventLookupResult, Peer: pid.String(), Source: "dht", Err: err}
+	for _, addr := range pi.Addrs {
+		e.Addrs = append(e.Addrs, addr.String())
+	}
+	ch.emit(e)
+	return pi, err
+}
+
+// watchPeers turns libp2p's connection and identify events into PeerEvents.
+// Only peers that advertise the chat protocol are reported as connected, so
+// DHT and bootstrap nodes never show up as contacts.
+func (ch *ChatHost) watchPeers(sub event.Subscription) {
+	defer sub.Close()
+	for e := range sub.Out() {
+		switch e := e.(type) {
+		case event.EvtPeerIdentificationCompleted:
+			if slices.Contains(e.Protocols, protocolID) {
+				ch.emit(PeerEvent{Kind: EventPeerConnected, Peer: e.Peer.String(), Source: "identify"})
+				go ch.sendPresence(e.Peer.String())
+			}
+		case event.EvtPeerConnectednessChanged:
+			if e.Connectedness == network.NotConnected && ch.speaksChat(e.Peer) {
+				ch.emit(PeerEvent{Kind: EventPeerDisconnected, Peer: e.Peer.String()})
+			}
+		}
+	}
+}
+
+// speaksChat reports whether identify saw pid advertise the chat protocol.
+func (ch *ChatHost) speaksChat(pid peer.ID) bool {
+	supported, _ := ch.P2PHost.Peerstore().SupportsProtocols(pid, protocolID)
+	return len(supported) > 0
+}
+
+// ChatPeers returns the connected peers that speak the chat protocol.
+func (ch *ChatHost) ChatPeers() []string {
+	var peers []string
+	for _, pid := range ch.P2PHost.Network().Peers() {
+		if ch.speaksChat(pid) {
+			peers = append(peers, pid.String())
+		}
+	}
+	return peers
+}
+
+// IsConnected reports whether we currently have a connection to peerIDStr.
+func (ch *ChatHost) IsConnected(peerIDStr string) bool {
+	pid, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return false
+	}
+	return ch.P2PHost.Network().Connectedness(pid) == network.Connected
+}
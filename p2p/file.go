@@ -0,0 +1,431 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const fileProtocolID = "/shellchat/file/1.0.0"
+
+const (
+	// FileChunkSize is how much file data is written to the stream at once.
+	FileChunkSize = 64 << 10
+	// MaxFileSize is the largest file we offer or accept.
+	MaxFileSize = 1 << 30
+
+	// OfferTimeout is how long an incoming offer waits for AnswerOffer.
+	OfferTimeout = 5 * time.Minute
+	// maxPendingOffers limits the unanswered offers of one peer.
+	maxPendingOffers = 3
+
+	fileProgressInterval = 200 * time.Millisecond
+)
+
+// ErrOfferDeclined is the error of an incoming offer the user declined or
+// did not answer in time.
+var ErrOfferDeclined = errors.New("file offer declined")
+
+// FileOffer opens a transfer. The sender writes it as one JSON line, the
+// receiver answers with a fileReply saying how much it already has, and the
+// sender streams the rest. A final fileReply reports whether the SHA-256 of
+// the whole file matched.
+type FileOffer struct {
+	ID   string `json:"id"` // hex SHA-256 of the content
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type fileReply struct {
+	Offset int64  `json:"offset"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PartialFile receives the data of one incoming file. It must keep what was
+// written across restarts so an interrupted transfer can resume.
+type PartialFile interface {
+	io.Writer
+	// Size is the number of bytes already received.
+	Size() int64
+	// Sum is the SHA-256 of those bytes.
+	Sum() []byte
+	// Discard deletes the data after a failed verification.
+	Discard() error
+	Close() error
+}
+
+// FileStore opens the PartialFile for a transfer id. size is the size of
+// the whole file, so the store can refuse files it has no room for.
+type FileStore func(id string, size int64) (PartialFile, error)
+
+// FileEvent reports the progress of a transfer in either direction. It is
+// published on the same bus as PeerEvent.
+type FileEvent struct {
+	FileOffer
+	Peer     string
+	Incoming bool
+	Offered  bool  // an incoming offer waiting for AnswerOffer
+	Done     int64 // bytes transferred, including resumed ones
+	Finished bool  // the transfer ended; Err says whether it worked
+	Err      error
+}
+
+// SetFileStore lets the host accept incoming files. Offers are refused
+// until a store is set, e.g. before the database is unlocked.
+func (ch *ChatHost) SetFileStore(store FileStore) {
+	ch.mu.Lock()
+	ch.files = store
+	ch.mu.Unlock()
+}
+
+// AnswerOffer accepts or declines the incoming offer of file id from a
+// peer. Once accepted, the same offer resumes without asking again until
+// it completes or the host stops.
+func (ch *ChatHost) AnswerOffer(peerIDStr, id string, accept bool) error {
+	ch.mu.Lock()
+	answer, ok := ch.offers[peerIDStr+"/"+id]
+	delete(ch.offers, peerIDStr+"/"+id)
+	ch.mu.Unlock()
+	if !ok {
+		return errors.New("no such file offer")
+	}
+	answer <- accept
+	return nil
+}
+
+// SendFile offers the file at path to a peer and streams it, continuing
+// where an earlier attempt stopped. Progress is published as FileEvents.
+func (ch *ChatHost) SendFile(ctx context.Context, peerIDStr, path string) (FileOffer, error) {
+	offer, err := newFileOffer(path)
+	if err != nil {
+		return offer, err
+	}
+	ev := FileEvent{FileOffer: offer, Peer: peerIDStr}
+	err = ch.sendFile(ctx, peerIDStr, path, &ev)
+	ev.Finished, ev.Err = true, err
+	ch.emitFile(ev)
+	return offer, err
+}
+
+func newFileOffer(path string) (FileOffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileOffer{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return FileOffer{}, err
+	}
+	if info.IsDir() {
+		return FileOffer{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxFileSize {
+		return FileOffer{}, fmt.Errorf("file is larger than %d MB", MaxFileSize>>20)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return FileOffer{}, err
+	}
+	return FileOffer{ID: hex.EncodeToString(h.Sum(nil)), Name: filepath.Base(path), Size: info.Size()}, nil
+}
+
+func (ch *ChatHost) sendFile(ctx context.Context, peerIDStr, path string, ev *FileEvent) error {
+	pid, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s, err := ch.P2PHost.NewStream(ctx, pid, fileProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	r := bufio.NewReader(s)
+	if err := writeJSONLine(s, ev.FileOffer); err != nil {
+		return err
+	}
+	reply, err := readFileReply(r)
+	if err != nil {
+		return err
+	}
+	if reply.Offset < 0 || reply.Offset > ev.Size {
+		return fmt.Errorf("peer asked to resume at invalid offset %d", reply.Offset)
+	}
+	if _, err := f.Seek(reply.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if reply.Offset > 0 {
+		log.Info("resuming file transfer", "peer", peerIDStr, "file", ev.Name, "offset", reply.Offset)
+	}
+
+	ev.Done = reply.Offset
+	w := &progressWriter{w: s, ev: ev, emit: ch.emitFile}
+	if _, err := io.CopyBuffer(w, io.LimitReader(f, ev.Size-reply.Offset), make([]byte, FileChunkSize)); err != nil {
+		return err
+	}
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+
+	// The final reply carries the receiver's verdict on the hash
+	_, err = readFileReply(r)
+	return err
+}
+
+func (ch *ChatHost) handleFileStream(s network.Stream) {
+	peerID := s.Conn().RemotePeer().String()
+	s.SetDeadline(time.Now().Add(time.Minute))
+	r := bufio.NewReader(s)
+
+	line, err := readLine(r)
+	if err != nil {
+		log.Debug("file stream failed", "peer", peerID, "err", err)
+		s.Reset()
+		return
+	}
+	var offer FileOffer
+	if err := json.Unmarshal(line, &offer); err != nil {
+		log.Debug("invalid file offer", "peer", peerID, "err", err)
+		s.Reset()
+		return
+	}
+	offer.Name = filepath.Base(offer.Name)
+
+	ev := FileEvent{FileOffer: offer, Peer: peerID, Incoming: true}
+	err = ch.receiveFile(s, r, &ev)
+	if err != nil {
+		log.Warn("file transfer failed", "peer", peerID, "file", offer.Name, "err", err)
+		writeJSONLine(s, fileReply{Offset: ev.Done, Error: err.Error()})
+	} else {
+		log.Info("file received", "peer", peerID, "file", offer.Name, "size", offer.Size)
+		writeJSONLine(s, fileReply{Offset: ev.Done})
+	}
+	s.Close()
+
+	ev.Finished, ev.Err = true, err
+	ch.emitFile(ev)
+}
+
+func (ch *ChatHost) receiveFile(s network.Stream, r *bufio.Reader, ev *FileEvent) error {
+	if _, err := hex.DecodeString(ev.ID); err != nil || len(ev.ID) != 2*sha256.Size {
+		return errors.New("invalid file id")
+	}
+	if ev.Size < 0 || ev.Size > MaxFileSize {
+		return fmt.Errorf("file size %d not accepted", ev.Size)
+	}
+
+	ch.mu.Lock()
+	store := ch.files
+	busy := ch.receiving[ev.ID]
+	if store != nil && !busy {
+		ch.receiving[ev.ID] = true
+	}
+	ch.mu.Unlock()
+	if store == nil {
+		return errors.New("not accepting files yet")
+	}
+	if busy {
+		return errors.New("file is already being received")
+	}
+	defer func() {
+		ch.mu.Lock()
+		delete(ch.receiving, ev.ID)
+		ch.mu.Unlock()
+	}()
+
+	if err := ch.awaitAnswer(s, ev); err != nil {
+		return err
+	}
+	// The history may have been locked while the offer waited
+	ch.mu.Lock()
+	store = ch.files
+	ch.mu.Unlock()
+	if store == nil {
+		return errors.New("not accepting files now")
+	}
+	part, err := store(ev.ID, ev.Size)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	// A partial file longer than the offer cannot be this file
+	if part.Size() > ev.Size {
+		part.Discard()
+		return errors.New("partial file does not match offer")
+	}
+
+	ev.Done = part.Size()
+	if err := writeJSONLine(s, fileReply{Offset: ev.Done}); err != nil {
+		return err
+	}
+
+	s.SetDeadline(time.Time{})
+	w := &progressWriter{w: part, ev: ev, emit: ch.emitFile, idle: s}
+	if _, err := io.CopyBuffer(w, io.LimitReader(r, ev.Size-ev.Done), make([]byte, FileChunkSize)); err != nil {
+		return err
+	}
+	if ev.Done != ev.Size {
+		return fmt.Errorf("transfer ended after %d of %d bytes", ev.Done, ev.Size)
+	}
+
+	if sum := hex.EncodeToString(part.Sum()); sum != ev.ID {
+		part.Discard()
+		return errors.New("SHA-256 mismatch, file discarded")
+	}
+	ch.mu.Lock()
+	delete(ch.accepted, ev.Peer+"/"+ev.ID)
+	ch.mu.Unlock()
+	return nil
+}
+
+// awaitAnswer publishes an incoming offer and waits for the user to answer
+// it through AnswerOffer. Offers accepted before resume right away.
+func (ch *ChatHost) awaitAnswer(s network.Stream, ev *FileEvent) error {
+	key := ev.Peer + "/" + ev.ID
+	ch.mu.Lock()
+	if ch.accepted[key] {
+		ch.mu.Unlock()
+		return nil
+	}
+	pending := 0
+	for k := range ch.offers {
+		if strings.HasPrefix(k, ev.Peer+"/") {
+			pending++
+		}
+	}
+	if pending >= maxPendingOffers {
+		ch.mu.Unlock()
+		return errors.New("too many pending file offers")
+	}
+	answer := make(chan bool, 1)
+	ch.offers[key] = answer
+	ch.mu.Unlock()
+
+	s.SetDeadline(time.Now().Add(OfferTimeout + time.Minute))
+	offer := *ev
+	offer.Offered = true
+	ch.emitFile(offer)
+
+	timer := time.NewTimer(OfferTimeout)
+	defer timer.Stop()
+	accept := false
+	select {
+	case accept = <-answer:
+	case <-timer.C:
+		ch.mu.Lock()
+		delete(ch.offers, key)
+		ch.mu.Unlock()
+		// AnswerOffer may have taken the offer just before it expired
+		select {
+		case accept = <-answer:
+		default:
+		}
+	}
+	if !accept {
+		return ErrOfferDeclined
+	}
+	ch.mu.Lock()
+	ch.accepted[key] = true
+	ch.mu.Unlock()
+	s.SetDeadline(time.Now().Add(time.Minute))
+	return nil
+}
+
+// progressWriter counts bytes through a transfer and publishes throttled
+// FileEvents. When idle is set, each write also pushes its read deadline
+// out so a stalled sender does not hold the stream forever.
+type progressWriter struct {
+	w    io.Writer
+	ev   *FileEvent
+	emit func(FileEvent)
+	idle network.Stream
+	last time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.ev.Done += int64(n)
+	if p.idle != nil {
+		p.idle.SetReadDeadline(time.Now().Add(time.Minute))
+	}
+	if time.Since(p.last) >= fileProgressInterval {
+		p.last = time.Now()
+		p.emit(*p.ev)
+	}
+	return n, err
+}
+
+func (ch *ChatHost) emitFile(e FileEvent) {
+	if ch.fileEmitter == nil {
+		return
+	}
+	if err := ch.fileEmitter.Emit(e); err != nil {
+		log.Debug("failed to emit file event", "err", err)
+	}
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func readFileReply(r *bufio.Reader) (fileReply, error) {
+	var reply fileReply
+	line, err := readLine(r)
+	if err != nil {
+		return reply, err
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(line), &reply); err != nil {
+		return reply, err
+	}
+	if reply.Error != "" {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
+
+// FormatSize renders a byte count for display, e.g. "1.5 MB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -1,33 +1,93 @@
 package p2p
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"sync"
 	"time"
 
+	"shellchat/config"
+	"shellchat/logging"
+
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	"github.com/multiformats/go-multiaddr"
 )
 
 const protocolID = "/shellchat/1.0.0"
 
+var log = logging.Logger("p2p")
+
 // ChatHost handles P2P connections
 type ChatHost struct {
 	P2PHost host.Host
 	DHT     *dht.IpfsDHT
-	MsgChan chan string // Channel to send incoming messages to UI
+	MsgChan chan Incoming // Channel to send incoming envelopes to UI
 	mu      sync.Mutex
 	streams map[string]network.Stream
+	writeMu map[string]*sync.Mutex // keeps concurrent frames to a peer whole
+	emitter event.Emitter
+	typing  typingThrottle
+
+	// File transfers
+	files       FileStore
+	receiving   map[string]bool      // file ids being received
+	offers      map[string]chan bool // peer/id -> answer to a waiting offer
+	accepted    map[string]bool      // peer/id offers the user accepted
+	fileEmitter event.Emitter
+
+	// Our own presence, sent to contacts as they connect
+	presence   string
+	statusText string
+
+	// Network health, reported by Diagnose
+	natMgr        basichost.NATManager
+	bootstrapDone chan struct{}
+	bootstrap     []BootstrapResult
+	mdnsRunning   bool
+	mdnsErr       error
+	mdnsPeers     map[peer.ID]bool // each peer announces itself repeatedly
+	discoveryErr  error
+}
+
+// BootstrapResult records the outcome of dialing one bootstrap peer.
+type BootstrapResult struct {
+	Peer  string `json:"peer"`
+	Error string `json:"error,omitempty"`
+}
+
+// ParseBootstrapPeers converts the configured bootstrap multiaddrs into AddrInfos,
+// falling back to the public IPFS bootstrap nodes when none are configured.
+func ParseBootstrapPeers(netCfg config.Network) ([]peer.AddrInfo, error) {
+	if len(netCfg.BootstrapPeers) == 0 {
+		return dht.GetDefaultBootstrapPeerAddrInfos(), nil
+	}
+	return parseAddrInfos(netCfg.BootstrapPeers)
+}
+
+// parseAddrInfos parses full /p2p/ multiaddrs, merging addresses of the same peer.
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	var mas []multiaddr.Multiaddr
+	for _, s := range addrs {
+		ma, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %q: %w", s, err)
+		}
+		mas = append(mas, ma)
+	}
+	return peer.AddrInfosFromP2pAddrs(mas...)
 }
 
-func MakeHost(port int, randomness io.Reader) (*ChatHost, error) {
+func MakeHost(port int, randomness io.Reader, netCfg config.Network) (*ChatHost, error) {
 	// Create identity
 	var priv crypto.PrivKey
 	var err error
@@ -41,15 +101,56 @@ func MakeHost(port int, randomness io.Reader) (*ChatHost, error) {
 		return nil, err
 	}
 
-	sourceMultiAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port))
+	bootstrapPeers, err := ParseBootstrapPeers(netCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	staticRelays, err := parseAddrInfos(netCfg.StaticRelays)
+	if err != nil {
+		return nil, err
+	}
+
+	listenAddrs := netCfg.ListenAddrs
+	if len(listenAddrs) == 0 {
+		listenAddrs = DefaultListenAddrs(port, netCfg.WebTransport)
+	}
+
+	// AutoRelay asks for candidates as soon as the host starts, before the DHT
+	// exists. The source must cope with that instead of dereferencing a nil DHT.
+	relays := &relaySource{static: staticRelays}
+	// Configured relays are trusted: reserve with them right away instead
+	// of waiting minutes for more candidates from the DHT.
+	var relayOpts []autorelay.Option
+	if len(staticRelays) > 0 {
+		relayOpts = append(relayOpts, autorelay.WithMinCandidates(len(staticRelays)), autorelay.WithBootDelay(0))
+	}
+
+	ch := &ChatHost{
+		MsgChan:       make(chan Incoming),
+		streams:       make(map[string]network.Stream),
+		writeMu:       make(map[string]*sync.Mutex),
+		receiving:     make(map[string]bool),
+		offers:        make(map[string]chan bool),
+		accepted:      make(map[string]bool),
+		mdnsPeers:     make(map[peer.ID]bool),
+		bootstrapDone: make(chan struct{}),
+	}
 
 	// Create libp2p Host with DHT, NAT, and Relay support
 	basicHost, err := libp2p.New(
-		libp2p.ListenAddrs(sourceMultiAddr),
+		libp2p.ListenAddrStrings(listenAddrs...),
 		libp2p.Identity(priv),
-		libp2p.NATPortMap(), // Try to punch through NAT (UPnP)
+		// Try to punch through NAT (UPnP), keeping the manager to report mappings
+		libp2p.NATManager(func(n network.Network) basichost.NATManager {
+			ch.natMgr = basichost.NewNATManager(n)
+			return ch.natMgr
+		}),
 		libp2p.EnableNATService(),
-		libp2p.EnableHolePunching(), // Enable Hole Punching instead of AutoRelay (Panic fix)
+		libp2p.EnableAutoNATv2(), // Per-address reachability, reported by `shellchat doctor`
+		libp2p.EnableRelay(),
+		libp2p.EnableAutoRelayWithPeerSource(relays.FindRelays, relayOpts...),
+		libp2p.EnableHolePunching(), // DCUtR upgrades relayed connections to direct ones
 	)
 	if err != nil {
 		return nil, err
@@ -57,44 +158,77 @@ func MakeHost(port int, randomness io.Reader) (*ChatHost, error) {
 
 	// Initialize DHT
 	// We use IDht to verify context, but New returns *IpfsDHT
-	kademliaDHT, err := dht.New(context.Background(), basicHost)
+	kademliaDHT, err := dht.New(context.Background(), basicHost, dht.BootstrapPeers(bootstrapPeers...))
 	if err != nil {
 		return nil, err
 	}
+	relays.setDHT(kademliaDHT)
 
 	// Bootstrap the DHT (connect to public bootstrap nodes)
 	if err = kademliaDHT.Bootstrap(context.Background()); err != nil {
 		return nil, err
 	}
 
-	// Connect to public bootstrap nodes to join the global network
-	// Connect to public bootstrap nodes (Background)
+	ch.P2PHost = basicHost
+	ch.DHT = kademliaDHT
+
+	// Publish peer state changes to the UI
+	ch.emitter, err = basicHost.EventBus().Emitter(new(PeerEvent))
+	if err != nil {
+		return nil, err
+	}
+	ch.fileEmitter, err = basicHost.EventBus().Emitter(new(FileEvent))
+	if err != nil {
+		return nil, err
+	}
+	peerSub, err := basicHost.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerConnectednessChanged),
+	})
+	if err != nil {
+		return nil, err
+	}
+	go ch.watchPeers(peerSub)
+
+	// Connect to the bootstrap nodes to join the network (Background)
 	go func() {
+		defer close(ch.bootstrapDone)
 		var wg sync.WaitGroup
-		for _, peerAddr := range dht.DefaultBootstrapPeers {
-			peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
+		for _, peerinfo := range bootstrapPeers {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // 10s timeout
 				defer cancel()
-				if err := basicHost.Connect(ctx, *peerinfo); err != nil {
-					// fmt.Println(err)
+				res := BootstrapResult{Peer: peerinfo.ID.String()}
+				if err := basicHost.Connect(ctx, peerinfo); err != nil {
+					log.Debug("bootstrap dial failed", "peer", peerinfo.ID, "err", err)
+					res.Error = err.Error()
 				}
+				ch.mu.Lock()
+				ch.bootstrap = append(ch.bootstrap, res)
+				ch.mu.Unlock()
 			}()
 		}
 		wg.Wait()
-		// fmt.Println("Bootstrap complete")
-	}()
 
-	ch := &ChatHost{
-		P2PHost: basicHost,
-		DHT:     kademliaDHT,
-		MsgChan: make(chan string),
-		streams: make(map[string]network.Stream),
-	}
+		connected := 0
+		ch.mu.Lock()
+		for _, res := range ch.bootstrap {
+			if res.Error == "" {
+				connected++
+			}
+		}
+		ch.mu.Unlock()
+		if connected == 0 {
+			log.Warn("could not reach any bootstrap peer", "tried", len(bootstrapPeers))
+		} else {
+			log.Info("bootstrap complete", "connected", connected, "tried", len(bootstrapPeers))
+		}
+	}()
 
 	basicHost.SetStreamHandler(protocolID, ch.handleStream)
+	basicHost.SetStreamHandler(fileProtocolID, ch.handleFileStream)
 
 	return ch, nil
 }
@@ -105,41 +239,101 @@ func (ch *ChatHost) handleStream(s network.Stream) {
 	ch.mu.Lock()
 	ch.streams[peerID] = s
 	ch.mu.Unlock()
+	defer ch.dropStream(peerID, s)
 
-	// Create a buffer stream for non blocking read and write.
-	buf := make([]byte, 1024)
-	for {
-		n, err := s.Read(buf)
-		if n > 0 {
-			msg := string(buf[:n])
-			// Format: "PEER_ID|MESSAGE"
-			ch.MsgChan <- fmt.Sprintf("%s|%s", peerID, msg)
-		}
-		if err != nil {
-			if err != io.EOF {
-				s.Reset()
-			} else {
-				s.Close()
-			}
-			break
-		}
+	if err := ch.readEnvelopes(peerID, bufio.NewReader(s)); err != io.EOF {
+		log.Debug("chat stream failed", "peer", peerID, "err", err)
+		s.Reset()
+	} else {
+		s.Close()
 	}
 }
 
-// SendMessage sends a message to a connected peer
-func (ch *ChatHost) SendMessage(ctx context.Context, peerIDStr string, msg string) error {
+// SendMessage sends a chat message to a connected peer. id is the message's
+// NewMessageID, which later edits and deletes refer to.
+func (ch *ChatHost) SendMessage(ctx context.Context, peerIDStr, id, msg string) error {
+	return ch.Send(ctx, peerIDStr, Envelope{Type: EnvMessage, ID: id, Body: msg})
+}
+
+// Send writes env to a connected peer, opening a stream if needed.
+func (ch *ChatHost) Send(ctx context.Context, peerIDStr string, env Envelope) error {
+	frame, err := encodeEnvelope(env)
+	if err != nil {
+		return err
+	}
+
+	ch.mu.Lock()
+	wmu, ok := ch.writeMu[peerIDStr]
+	if !ok {
+		wmu = new(sync.Mutex)
+		ch.writeMu[peerIDStr] = wmu
+	}
+	ch.mu.Unlock()
+	wmu.Lock()
+	defer wmu.Unlock()
+
 	ch.mu.Lock()
 	s, ok := ch.streams[peerIDStr]
 	ch.mu.Unlock()
 
 	if !ok {
-		// Try to find and connect
-		// This requires resolving peerID string to PeerID
-		// And finding address via DHT if not connected
-		// For now, assume connected via UI connect command or mDNS.
-		return fmt.Errorf("peer not connected")
+		var err error
+		s, err = ch.openStream(ctx, peerIDStr)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err := s.Write([]byte(msg))
-	return err
+	if _, err := s.Write(frame); err != nil {
+		// The stream may have died with a relayed connection that was since
+		// upgraded to a direct one; retry once on a fresh stream.
+		ch.dropStream(peerIDStr, s)
+		s, err = ch.openStream(ctx, peerIDStr)
+		if err != nil {
+			return err
+		}
+		_, err = s.Write(frame)
+		return err
+	}
+	return nil
+}
+
+// openStream opens an outgoing chat stream to an already connected peer.
+// Limited (relayed) connections are allowed so peers behind symmetric NATs
+// can talk until DCUtR manages a direct connection.
+func (ch *ChatHost) openStream(ctx context.Context, peerIDStr string) (network.Stream, error) {
+	pid, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if ch.P2PHost.Network().Connectedness(pid) == network.NotConnected {
+		return nil, fmt.Errorf("peer not connected")
+	}
+	// Skip peers (such as DHT nodes) that we know do not speak our protocol.
+	if protos, _ := ch.P2PHost.Peerstore().GetProtocols(pid); len(protos) > 0 {
+		if supported, _ := ch.P2PHost.Peerstore().SupportsProtocols(pid, protocolID); len(supported) == 0 {
+			return nil, fmt.Errorf("peer does not support %s", protocolID)
+		}
+	}
+
+	s, err := ch.P2PHost.NewStream(network.WithAllowLimitedConn(ctx, "chat"), pid, protocolID)
+	if err != nil {
+		return nil, err
+	}
+
+	ch.mu.Lock()
+	ch.streams[peerIDStr] = s
+	ch.mu.Unlock()
+
+	go ch.handleStream(s)
+	return s, nil
+}
+
+// dropStream forgets s if it is still the current stream for peerIDStr.
+func (ch *ChatHost) dropStream(peerIDStr string, s network.Stream) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.streams[peerIDStr] == s {
+		delete(ch.streams, peerIDStr)
+	}
 }
@@ -0,0 +1,69 @@
+package p2p
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Presence states a user can publish. Offline is never sent; it is what a
+// front-end shows for a contact we have no connection to.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceDND     = "dnd"
+	PresenceOffline = "offline"
+)
+
+// ValidPresence reports whether state can be published with SetPresence.
+func ValidPresence(state string) bool {
+	switch state {
+	case PresenceOnline, PresenceAway, PresenceDND:
+		return true
+	}
+	return false
+}
+
+// ParseStatus interprets the argument of a /status command: an optional
+// presence state followed by status text. Without a state the current one
+// is kept, so "/status lunch" only changes the text and "/status away" alone
+// clears it.
+func ParseStatus(arg, current string) (state, text string) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	if ValidPresence(strings.ToLower(first)) {
+		return strings.ToLower(first), strings.TrimSpace(rest)
+	}
+	return current, strings.TrimSpace(arg)
+}
+
+// Presence returns our current presence state and status text.
+func (ch *ChatHost) Presence() (state, text string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.presence == "" {
+		return PresenceOnline, ch.statusText
+	}
+	return ch.presence, ch.statusText
+}
+
+// SetPresence changes our presence and tells every connected contact.
+// Contacts that connect later receive it when identify completes.
+func (ch *ChatHost) SetPresence(state, text string) {
+	ch.mu.Lock()
+	ch.presence = state
+	ch.statusText = text
+	ch.mu.Unlock()
+
+	for _, p := range ch.ChatPeers() {
+		go ch.sendPresence(p)
+	}
+}
+
+func (ch *ChatHost) sendPresence(peerID string) {
+	state, text := ch.Presence()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.Send(ctx, peerID, Envelope{Type: EnvPresence, Presence: state, Status: text}); err != nil {
+		log.Debug("presence update failed", "peer", peerID, "err", err)
+	}
+}
@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// RelayLimits bounds the resources a relay node hands out to its clients.
+type RelayLimits struct {
+	MaxConns        int
+	MaxMemoryMB     int
+	MaxReservations int
+	MaxCircuits     int
+	CircuitDuration time.Duration
+	CircuitData     int64
+}
+
+// RelayNode is a headless node that serves the DHT, circuit relay v2 and
+// AutoNAT to chat clients. It never opens the chat protocol or storage.
+type RelayNode struct {
+	P2PHost host.Host
+	DHT     *dht.IpfsDHT
+}
+
+// MakeRelayNode starts a relay node listening on port with a fixed identity.
+// bootstrap may be empty for the first node of a private deployment.
+func MakeRelayNode(port int, priv crypto.PrivKey, bootstrap []peer.AddrInfo, limits RelayLimits, forcePublic bool) (*RelayNode, error) {
+	limiter := rcmgr.NewFixedLimiter(rcmgr.PartialLimitConfig{
+		System: rcmgr.ResourceLimits{
+			Conns:  rcmgr.LimitVal(limits.MaxConns),
+			Memory: rcmgr.LimitVal64(int64(limits.MaxMemoryMB) << 20),
+		},
+	}.Build(rcmgr.DefaultLimits.AutoScale()))
+	mgr, err := rcmgr.NewResourceManager(limiter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+
+	resources := relay.DefaultResources()
+	if limits.MaxReservations > 0 {
+		resources.MaxReservations = limits.MaxReservations
+	}
+	if limits.MaxCircuits > 0 {
+		resources.MaxCircuits = limits.MaxCircuits
+	}
+	if limits.CircuitDuration > 0 {
+		resources.Limit.Duration = limits.CircuitDuration
+	}
+	if limits.CircuitData > 0 {
+		resources.Limit.Data = limits.CircuitData
+	}
+
+	opts := []libp2p.Option{
+		libp2p.ListenAddrStrings(DefaultListenAddrs(port, false)...),
+		libp2p.Identity(priv),
+		libp2p.ResourceManager(mgr),
+		libp2p.NATPortMap(),
+		libp2p.EnableNATService(),
+		libp2p.EnableRelayService(relay.WithResources(resources)),
+	}
+	// The relay service only activates once the node believes it is publicly
+	// reachable, which AutoNAT may never confirm in a small private network.
+	if forcePublic {
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	}
+
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	kademliaDHT, err := dht.New(context.Background(), h,
+		dht.Mode(dht.ModeServer),
+		dht.BootstrapPeers(bootstrap...),
+	)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	if err := kademliaDHT.Bootstrap(context.Background()); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	for _, pi := range bootstrap {
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := h.Connect(ctx, pi); err != nil {
+				log.Warn("relay bootstrap dial failed", "peer", pi.ID, "err", err)
+			}
+		}(pi)
+	}
+	wg.Wait()
+
+	return &RelayNode{P2PHost: h, DHT: kademliaDHT}, nil
+}
+
+// AddrGroups returns the node's addresses with its Peer ID appended, grouped
+// by transport and ready to be pasted into a client's bootstrap_peers.
+func (r *RelayNode) AddrGroups() []AddrGroup {
+	return GroupAddrs(r.P2PHost.Addrs(), "/p2p/"+r.P2PHost.ID().String())
+}
+
+// Close shuts down the DHT and the host.
+func (r *RelayNode) Close() error {
+	r.DHT.Close()
+	return r.P2PHost.Close()
+}
+
+// relaySource feeds AutoRelay with relay candidates: the configured static
+// relays first, then peers from the DHT routing table. AutoRelay itself
+// checks which candidates actually offer the relay v2 hop protocol.
+type relaySource struct {
+	static []peer.AddrInfo
+
+	mu  sync.Mutex
+	dht *dht.IpfsDHT
+}
+
+func (r *relaySource) setDHT(d *dht.IpfsDHT) {
+	r.mu.Lock()
+	r.dht = d
+	r.mu.Unlock()
+}
+
+// FindRelays implements autorelay.PeerSource.
+func (r *relaySource) FindRelays(ctx context.Context, num int) <-chan peer.AddrInfo {
+	r.mu.Lock()
+	d := r.dht
+	r.mu.Unlock()
+
+	out := make(chan peer.AddrInfo, num)
+	go func() {
+		defer close(out)
+		candidates := append([]peer.AddrInfo(nil), r.static...)
+		if d != nil {
+			for _, pid := range d.RoutingTable().ListPeers() {
+				candidates = append(candidates, d.Host().Peerstore().PeerInfo(pid))
+			}
+		}
+		for i, pi := range candidates {
+			if i >= num {
+				return
+			}
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ConnKind reports how we are connected to peerIDStr: "direct", "relayed",
+// or "" when there is no connection. A direct connection wins over a relayed
+// one, so this flips to "direct" as soon as a DCUtR hole punch succeeds.
+func (ch *ChatHost) ConnKind(peerIDStr string) string {
+	pid, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return ""
+	}
+	kind := ""
+	for _, c := range ch.P2PHost.Network().ConnsToPeer(pid) {
+		if c.Stat().Limited || isRelayAddr(c.RemoteMultiaddr()) {
+			kind = "relayed"
+			continue
+		}
+		return "direct"
+	}
+	return kind
+}
+
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+	return strings.Contains(addr.String(), "/p2p-circuit")
+}
@@ -0,0 +1,128 @@
+package p2p
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"shellchat/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// natClient starts a chat host that uses relay as its only bootstrap peer
+// and static relay, then closes its listeners so, like a peer behind a
+// symmetric NAT, it can only be reached through the relay. AutoNAT cannot
+// tell that on loopback, so its verdict is emitted here.
+func natClient(t *testing.T, relay string) *ChatHost {
+	t.Helper()
+	ch, err := MakeHost(0, nil, config.Network{
+		BootstrapPeers: []string{relay},
+		StaticRelays:   []string{relay},
+		ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+	})
+	if err != nil {
+		t.Fatalf("MakeHost: %v", err)
+	}
+	t.Cleanup(func() {
+		ch.DHT.Close()
+		ch.P2PHost.Close()
+	})
+	sw := ch.P2PHost.Network().(*swarm.Swarm)
+	for _, a := range sw.ListenAddresses() {
+		if !isRelayAddr(a) {
+			sw.ListenClose(a)
+		}
+	}
+
+	em, err := ch.P2PHost.EventBus().Emitter(new(event.EvtLocalReachabilityChanged), eventbus.Stateful)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+	if err := em.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate}); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestChatOverStaticRelay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts three libp2p nodes")
+	}
+	t.Parallel()
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := MakeRelayNode(0, priv, nil, RelayLimits{}, true)
+	if err != nil {
+		t.Fatalf("MakeRelayNode: %v", err)
+	}
+	t.Cleanup(func() { node.Close() })
+
+	var relayAddr multiaddr.Multiaddr
+	for _, a := range node.P2PHost.Addrs() {
+		if strings.HasPrefix(a.String(), "/ip4/127.0.0.1/tcp/") {
+			relayAddr = a
+		}
+	}
+	if relayAddr == nil {
+		t.Fatalf("relay has no loopback TCP address in %v", node.P2PHost.Addrs())
+	}
+	relay := relayAddr.String() + "/p2p/" + node.P2PHost.ID().String()
+
+	alice := natClient(t, relay)
+	bob := natClient(t, relay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	circuit, err := multiaddr.NewMultiaddr(relay + "/p2p-circuit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobID := bob.P2PHost.ID()
+	// Bob is reachable once AutoRelay holds a reservation with the relay.
+	// No event says so for loopback relays, so keep dialing until then.
+	for {
+		err := alice.Connect(ctx, peer.AddrInfo{ID: bobID, Addrs: []multiaddr.Multiaddr{circuit}}, "test")
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Connect through the relay: %v", err)
+		}
+		alice.P2PHost.Network().(*swarm.Swarm).Backoff().Clear(bobID)
+		time.Sleep(200 * time.Millisecond)
+	}
+	if kind := alice.ConnKind(bobID.String()); kind != "relayed" {
+		t.Errorf("ConnKind = %q, want relayed", kind)
+	}
+
+	if err := alice.Send(ctx, bobID.String(), Envelope{Type: EnvMessage, ID: "m1", Body: "hi"}); err != nil {
+		t.Fatalf("Send over the relayed connection: %v", err)
+	}
+	for {
+		select {
+		case in := <-bob.MsgChan:
+			if in.Type != EnvMessage {
+				continue // presence sent on connect
+			}
+			if in.Body != "hi" || in.Peer != alice.P2PHost.ID().String() {
+				t.Errorf("bob received %+v", in)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("message did not arrive over the relay")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"shellchat/p2p"
+	"shellchat/storage"
+)
+
+// Offers returns the incoming file offers waiting for an answer, oldest
+// first.
+func (s *Session) Offers() []p2p.FileEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]p2p.FileEvent(nil), s.offers...)
+}
+
+// Offer returns the newest waiting offer, or with name set, the newest
+// offer of a file called name.
+func (s *Session) Offer(name string) (p2p.FileEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.offers) - 1; i >= 0; i-- {
+		if name == "" || s.offers[i].Name == name {
+			return s.offers[i], true
+		}
+	}
+	return p2p.FileEvent{}, false
+}
+
+// AnswerOffer accepts or declines an offer returned by Offers or Offer.
+func (s *Session) AnswerOffer(e p2p.FileEvent, accept bool) error {
+	s.mu.Lock()
+	s.dropOffer(e.Peer, e.ID)
+	s.mu.Unlock()
+	if s.host == nil {
+		return errors.New("not connected to the P2P network")
+	}
+	return s.host.AnswerOffer(e.Peer, e.ID, accept)
+}
+
+// trackOffer keeps the list of waiting offers current with e.
+func (s *Session) trackOffer(e p2p.FileEvent) {
+	if !e.Incoming {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case e.Offered:
+		s.offers = append(s.offers, e)
+	case e.Finished:
+		// Offers that expired unanswered end here too
+		s.dropOffer(e.Peer, e.ID)
+	}
+}
+
+// dropOffer removes an offer from the list. Callers hold s.mu.
+func (s *Session) dropOffer(peer, id string) {
+	for i, o := range s.offers {
+		if o.Peer == peer && o.ID == id {
+			s.offers = append(s.offers[:i], s.offers[i+1:]...)
+			return
+		}
+	}
+}
+
+// expirePartials deletes the incomplete files in st that files.expire_partial
+// says were abandoned.
+func (s *Session) expirePartials(st *storage.Store) {
+	spec := s.cfg.Files.ExpirePartial
+	if spec == "" || spec == "0" {
+		return
+	}
+	maxAge, err := time.ParseDuration(spec)
+	if err != nil {
+		log.Warn("invalid files.expire_partial, ignored", "value", spec, "err", err)
+		return
+	}
+	n, err := st.ExpirePartials(context.Background(), maxAge)
+	if err != nil {
+		log.Warn("failed to expire partial files", "err", err)
+	}
+	if n > 0 {
+		log.Info("expired partial files", "count", n)
+	}
+}
+
+// fileStore adapts st.OpenPartial to p2p.FileStore. With quota above zero,
+// it refuses files that would take the received files past quota bytes,
+// counting the rest of every transfer in progress as taken.
+func fileStore(st *storage.Store, quota int64) p2p.FileStore {
+	var mu sync.Mutex
+	var reserved int64
+	return func(id string, size int64) (p2p.PartialFile, error) {
+		p, err := st.OpenPartial(id)
+		if err != nil {
+			return nil, err
+		}
+		if quota <= 0 {
+			return p, nil
+		}
+		used, err := st.FilesSize()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		need := max(size-p.Size(), 0)
+		mu.Lock()
+		fits := used+reserved+need <= quota
+		if fits {
+			reserved += need
+		}
+		mu.Unlock()
+		if !fits {
+			if p.Size() == 0 {
+				p.Discard()
+			} else {
+				p.Close()
+			}
+			return nil, fmt.Errorf("file does not fit in the %d MB quota for received files", quota>>20)
+		}
+		release := sync.OnceFunc(func() {
+			mu.Lock()
+			reserved -= need
+			mu.Unlock()
+		})
+		return &reservedFile{PartialFile: p, release: release}, nil
+	}
+}
+
+// reservedFile gives back its share of the quota when the transfer ends.
+type reservedFile struct {
+	*storage.PartialFile
+	release func()
+}
+
+func (f *reservedFile) Discard() error {
+	f.release()
+	return f.PartialFile.Discard()
+}
+
+func (f *reservedFile) Close() error {
+	f.release()
+	return f.PartialFile.Close()
+}
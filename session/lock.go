@@ -0,0 +1,163 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"shellchat/p2p"
+	"shellchat/storage"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// maxQueued bounds the envelopes kept while locked; later ones are dropped.
+const maxQueued = 10000
+
+// ErrLocked is returned for history operations while the session is locked.
+var ErrLocked = errors.New("history is locked")
+
+// ErrWrongPassword is returned by Unlock when the password does not open
+// the envelopes queued while locked.
+var ErrWrongPassword = errors.New("wrong password")
+
+// lockedQueue holds the envelopes that arrive while the session is locked.
+// Each is sealed to a key pair made at lock time whose private half was
+// encrypted with the session key before that was wiped, so only the
+// password can read them again.
+type lockedQueue struct {
+	public  *[32]byte
+	private string // encrypted with the session key
+	sealed  [][]byte
+	dropped int
+}
+
+// Lock closes the history and wipes its key from memory. Until Unlock,
+// incoming files are refused and envelopes are kept sealed in memory;
+// typing notices are dropped.
+func (s *Session) Lock() error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	wrapped, err := st.Encrypt(string(private[:]))
+	clear(private[:])
+	if err != nil {
+		return err
+	}
+
+	if s.host != nil {
+		s.host.SetFileStore(nil)
+	}
+	s.mu.Lock()
+	s.store = nil
+	s.queue = &lockedQueue{public: public, private: wrapped}
+	clear(s.typing)
+	s.mu.Unlock()
+	return st.Close()
+}
+
+// history returns the open history. It guards the operations front-ends
+// may still start from commands in flight when the session locks.
+func (s *Session) history() (*storage.Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil, ErrLocked
+	}
+	return s.store, nil
+}
+
+// Locked reports whether Lock was called without a successful Unlock.
+func (s *Session) Locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue != nil
+}
+
+// enqueue seals an envelope that arrived while locked. It reports false
+// when the session is unlocked.
+func (s *Session) enqueue(in p2p.Incoming) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue
+	if q == nil {
+		return false
+	}
+	if in.Type == p2p.EnvTyping {
+		return true
+	}
+	if len(q.sealed) >= maxQueued {
+		q.dropped++
+		return true
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		log.Error("failed to queue envelope", "peer", in.Peer, "err", err)
+		return true
+	}
+	sealed, err := box.SealAnonymous(nil, data, q.public, rand.Reader)
+	clear(data)
+	if err != nil {
+		log.Error("failed to queue envelope", "peer", in.Peer, "err", err)
+		return true
+	}
+	q.sealed = append(q.sealed, sealed)
+	return true
+}
+
+// replay opens the queue with st, the history Unlock opened, and receives
+// its envelopes in order. The session is unlocked once the queue opens.
+func (s *Session) replay(st *storage.Store) error {
+	s.mu.Lock()
+	q := s.queue
+	s.mu.Unlock()
+	if q == nil {
+		// Unlocking an open session replaces its history
+		s.mu.Lock()
+		old := s.store
+		s.store = st
+		s.mu.Unlock()
+		if old != nil {
+			if err := old.Close(); err != nil {
+				log.Warn("failed to close the replaced history", "err", err)
+			}
+		}
+		return nil
+	}
+	plain, err := st.Decrypt(q.private)
+	if err != nil || len(plain) != 32 {
+		return ErrWrongPassword
+	}
+	var private [32]byte
+	copy(private[:], plain)
+	defer clear(private[:])
+
+	s.mu.Lock()
+	s.store = st
+	s.queue = nil
+	s.mu.Unlock()
+	if q.dropped > 0 {
+		log.Warn("envelopes dropped while locked", "count", q.dropped)
+	}
+	for _, sealed := range q.sealed {
+		data, ok := box.OpenAnonymous(nil, sealed, q.public, &private)
+		if !ok {
+			log.Error("failed to open queued envelope")
+			continue
+		}
+		var in p2p.Incoming
+		err := json.Unmarshal(data, &in)
+		clear(data)
+		if err != nil {
+			log.Error("failed to decode queued envelope", "err", err)
+			continue
+		}
+		s.Receive(in)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"shellchat/commands"
+	"shellchat/p2p"
+	"shellchat/storage"
+)
+
+// EventKind says what an incoming envelope changed.
+type EventKind int
+
+const (
+	// EventMessage: a message arrived in Peer's conversation.
+	EventMessage EventKind = iota
+	// EventChanged: Peer edited, deleted or reacted to a stored message.
+	EventChanged
+	// EventTyping: Peer is typing.
+	EventTyping
+	// EventPresence: Peer published a new presence state or status text.
+	EventPresence
+)
+
+// Event describes what Receive stored, for the front-ends to redraw.
+type Event struct {
+	Kind EventKind
+	Peer string
+	Text string // the message, for EventMessage
+}
+
+// Receive applies an envelope from a peer: messages, edits, deletes,
+// reactions and presence are stored, typing notices remembered. It reports
+// false for envelopes that change nothing, such as an edit of a message
+// the peer did not write, and for those queued while locked.
+func (s *Session) Receive(in p2p.Incoming) (Event, bool) {
+	ev := Event{Peer: in.Peer}
+	if s.enqueue(in) {
+		return ev, false
+	}
+	now := time.Now()
+	switch in.Type {
+	case p2p.EnvTyping:
+		if !s.cfg.Privacy.TypingIndicators {
+			return ev, false
+		}
+		s.mu.Lock()
+		s.typing[in.Peer] = now
+		s.mu.Unlock()
+		ev.Kind = EventTyping
+
+	case p2p.EnvPresence:
+		if st, err := s.history(); err == nil {
+			if err := st.SavePresence(context.Background(), in.Peer, in.Presence, in.Status, now.Unix()); err != nil {
+				log.Error("failed to save presence", "peer", in.Peer, "err", err)
+			}
+		}
+		s.mu.Lock()
+		c := s.contacts[in.Peer]
+		c.PeerID, c.Presence, c.StatusText, c.UpdatedAt = in.Peer, in.Presence, in.Status, now.Unix()
+		s.contacts[in.Peer] = c
+		s.addPeer(in.Peer)
+		s.mu.Unlock()
+		ev.Kind = EventPresence
+
+	case p2p.EnvMessage:
+		if st, err := s.history(); err == nil {
+			if err := st.SaveMessage(context.Background(), in.Peer, in.ID, in.ReplyTo, in.Body, now.Unix(), false); err != nil {
+				log.Error("failed to save incoming message", "peer", in.Peer, "err", err)
+			}
+		}
+		s.mu.Lock()
+		delete(s.typing, in.Peer)
+		reading := s.received(in.Peer, now)
+		s.mu.Unlock()
+		if reading {
+			s.markRead(in.Peer)
+		}
+		ev.Kind = EventMessage
+		ev.Text = in.Body
+
+	case p2p.EnvEdit, p2p.EnvDelete, p2p.EnvReaction:
+		if !s.applyChange(in, now) {
+			return ev, false
+		}
+		ev.Kind = EventChanged
+
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
+// applyChange stores an edit, delete or reaction a peer sent. It reports
+// false when there is nothing to update.
+func (s *Session) applyChange(in p2p.Incoming, now time.Time) bool {
+	st, err := s.history()
+	if err != nil {
+		return false
+	}
+	switch in.Type {
+	case p2p.EnvEdit:
+		err = st.EditMessage(context.Background(), in.Target, in.Peer, false, in.Body, now.Unix())
+	case p2p.EnvDelete:
+		err = st.DeleteMessage(context.Background(), in.Target, in.Peer, false)
+	case p2p.EnvReaction:
+		if in.Target == "" || !ValidReaction(in.Body) {
+			return false
+		}
+		err = st.SetReaction(context.Background(), in.Target, in.Peer, in.Body, in.Remove, now.Unix())
+	}
+	if err != nil {
+		log.Debug("ignoring message change", "type", in.Type, "peer", in.Peer, "target", in.Target, "err", err)
+		return false
+	}
+	return true
+}
+
+// ReceiveFile records a verified incoming file in the history and keeps
+// Offers current. Other file events only report progress and are ignored.
+// It reports whether the history changed.
+func (s *Session) ReceiveFile(e p2p.FileEvent) bool {
+	s.trackOffer(e)
+	if !e.Incoming || !e.Finished || e.Err != nil {
+		return false
+	}
+	st, err := s.history()
+	if err != nil {
+		return false
+	}
+	file := storage.File{ID: e.ID, Name: e.Name, Size: e.Size, Stored: true}
+	if err := st.SaveFileMessage(context.Background(), e.Peer, file, time.Now().Unix(), false); err != nil {
+		log.Error("failed to save incoming file", "peer", e.Peer, "err", err)
+		return false
+	}
+	s.mu.Lock()
+	reading := s.received(e.Peer, time.Now())
+	s.mu.Unlock()
+	if reading {
+		s.markRead(e.Peer)
+	}
+	return true
+}
+
+// FileSent records a file we sent to peer.
+func (s *Session) FileSent(peer string, offer p2p.FileOffer) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	file := storage.File{ID: offer.ID, Name: offer.Name, Size: offer.Size}
+	if err := st.SaveFileMessage(context.Background(), peer, file, now.Unix(), true); err != nil {
+		return err
+	}
+	s.sent(peer, now)
+	return nil
+}
+
+// Send stores a message in peer's conversation and sends it. replyTo is the
+// MsgID of the message it answers, or empty. Like every change to
+// messages, it goes to all connected peers; those without the conversation
+// keep it in theirs with us.
+func (s *Session) Send(peer, replyTo, content string) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	msgID := p2p.NewMessageID()
+	now := time.Now()
+	if err := st.SaveMessage(context.Background(), peer, msgID, replyTo, content, now.Unix(), true); err != nil {
+		return err
+	}
+	s.sent(peer, now)
+	s.broadcast(p2p.Envelope{Type: p2p.EnvMessage, ID: msgID, ReplyTo: replyTo, Body: content})
+	return nil
+}
+
+// Edit replaces the text of one of our messages for everyone.
+func (s *Session) Edit(msgID, content string) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	if err := st.EditMessage(context.Background(), msgID, "", true, content, time.Now().Unix()); err != nil {
+		return err
+	}
+	s.broadcast(p2p.Envelope{Type: p2p.EnvEdit, Target: msgID, Body: content})
+	return nil
+}
+
+// Delete retracts one of our messages for everyone.
+func (s *Session) Delete(msgID string) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	if err := st.DeleteMessage(context.Background(), msgID, "", true); err != nil {
+		return err
+	}
+	s.broadcast(p2p.Envelope{Type: p2p.EnvDelete, Target: msgID})
+	return nil
+}
+
+// React adds our emoji to a message, or takes it back if we already
+// reacted with it, and tells our peers. It reports whether the reaction is
+// now present.
+func (s *Session) React(msgID, emoji string) (bool, error) {
+	if !ValidReaction(emoji) {
+		return false, errors.New("usage: /react <emoji>")
+	}
+	st, err := s.history()
+	if err != nil {
+		return false, err
+	}
+	added, err := st.ToggleReaction(context.Background(), msgID, emoji, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	s.broadcast(p2p.Envelope{Type: p2p.EnvReaction, Target: msgID, Body: emoji, Remove: !added})
+	return added, nil
+}
+
+// ValidReaction keeps reactions to a short run of non-space characters.
+func ValidReaction(emoji string) bool {
+	return emoji != "" && len(emoji) <= 32 && strings.IndexFunc(emoji, unicode.IsSpace) < 0
+}
+
+// NotifyTyping lets the active conversation know we are typing input.
+// Commands are not chat, so they stay private.
+func (s *Session) NotifyTyping(active, input string) {
+	if s.host == nil || !s.cfg.Privacy.TypingIndicators || input == "" || commands.IsCommand(input) {
+		return
+	}
+	if active == GlobalRoom {
+		s.host.NotifyTyping(s.host.ChatPeers()...)
+		return
+	}
+	s.host.NotifyTyping(active)
+}
+
+// broadcast sends env to every connected chat peer without waiting.
+// Failures are only logged; peers that never saw a Target ignore it.
+func (s *Session) broadcast(env p2p.Envelope) {
+	if s.host == nil {
+		return
+	}
+	for _, p := range s.host.ChatPeers() {
+		go func(pid string) {
+			if err := s.host.Send(context.Background(), pid, env); err != nil {
+				log.Debug("send failed", "peer", pid, "type", env.Type, "err", err)
+			}
+		}(p)
+	}
+}
@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shellchat/p2p"
+)
+
+// notifyPreviewLen is how much of a message a notification shows.
+const notifyPreviewLen = 100
+
+// Notification announces an incoming message.
+type Notification struct {
+	Title string
+	Body  string
+}
+
+// quietHours is a daily window in minutes after midnight. It wraps around
+// midnight when end is before start.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours reads a window such as "22:00-07:00".
+func parseQuietHours(spec string) (quietHours, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return quietHours{}, fmt.Errorf("want HH:MM-HH:MM, got %q", spec)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return quietHours{}, err
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return quietHours{}, err
+	}
+	return quietHours{start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute()}, nil
+}
+
+func (q quietHours) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if q.start <= q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// Notification returns the announcement for ev, or false when there should
+// be none: for anything but messages, for muted conversations, while our
+// status is dnd or quiet hours are on, and for the open conversation while
+// the window has focus. The text stays hidden unless configured otherwise.
+func (s *Session) Notification(ev Event, focused bool, now time.Time) (Notification, bool) {
+	if ev.Kind != EventMessage {
+		return Notification{}, false
+	}
+	if state, _ := s.Status(); state == p2p.PresenceDND {
+		return Notification{}, false
+	}
+	if s.quiet != nil && s.quiet.contains(now) {
+		return Notification{}, false
+	}
+
+	s.mu.Lock()
+	muted, open := s.muted[ev.Peer], focused && ev.Peer == s.active
+	name := s.contacts[ev.Peer].Nickname
+	s.mu.Unlock()
+	if muted || open {
+		return Notification{}, false
+	}
+
+	if name == "" {
+		name = ev.Peer
+		if len(name) > 12 {
+			name = name[:12] + "..."
+		}
+	}
+	n := Notification{Title: name, Body: "New message"}
+	if s.cfg.Notifications.ShowContent {
+		n.Body = ev.Text
+		if r := []rune(n.Body); len(r) > notifyPreviewLen {
+			n.Body = string(r[:notifyPreviewLen]) + "…"
+		}
+	}
+	return n, true
+}
+
+// Muted reports whether peer's conversation is muted.
+func (s *Session) Muted(peer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.muted[peer]
+}
+
+// ErrMuteGlobalRoom is returned by ToggleMute for the global room. It shows
+// every conversation, so muting it could only mean muting them all.
+var ErrMuteGlobalRoom = errors.New("the global room cannot be muted; open a conversation to mute it")
+
+// ToggleMute mutes or unmutes peer's conversation and reports whether it
+// is now muted.
+func (s *Session) ToggleMute(peer string) (bool, error) {
+	if peer == GlobalRoom {
+		return false, ErrMuteGlobalRoom
+	}
+	st, err := s.history()
+	if err != nil {
+		return s.Muted(peer), err
+	}
+	muted := !s.Muted(peer)
+	if err := st.SetMuted(context.Background(), peer, muted); err != nil {
+		return !muted, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if muted {
+		s.muted[peer] = true
+	} else {
+		delete(s.muted, peer)
+	}
+	return muted, nil
+}
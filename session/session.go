@@ -0,0 +1,472 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"shellchat/config"
+	"shellchat/logging"
+	"shellchat/p2p"
+	"shellchat/storage"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var log = logging.Logger("session")
+
+// GlobalRoom is the conversation shared with every connected peer. It is
+// always the first conversation.
+const GlobalRoom = "global-room"
+
+// HistoryLimit is how many messages of a conversation are loaded.
+const HistoryLimit = 50
+
+// TypingTimeout hides an indicator when the peer stops sending notices.
+const TypingTimeout = 2 * p2p.TypingInterval
+
+// Host is the part of the P2P node a Session drives. *p2p.ChatHost
+// implements it.
+type Host interface {
+	Send(ctx context.Context, peerID string, env p2p.Envelope) error
+	ChatPeers() []string
+	Presence() (state, text string)
+	SetPresence(state, text string)
+	NotifyTyping(peers ...string)
+	SetFileStore(store p2p.FileStore)
+	AnswerOffer(peerID, id string, accept bool) error
+}
+
+// Session is the chat state the TUI and the GUI share: the unlocked
+// history, the conversations and contacts, and sending and receiving
+// envelopes. Front-ends only render it. It is safe for concurrent use.
+type Session struct {
+	host  Host
+	cfg   *config.Config
+	quiet *quietHours // nil when not configured
+
+	mu       sync.Mutex
+	peers    []string         // conversations after the global room, in order seen
+	active   string           // the conversation the user has open
+	activity map[string]int64 // peer -> time of the newest message
+	unread   map[string]int
+	muted    map[string]bool
+	online   map[string]bool
+	contacts map[string]storage.Contact
+	typing   map[string]time.Time // peer -> last typing notice
+	store    *storage.Store       // nil until Unlock and while locked
+	queue    *lockedQueue         // set while locked
+	offers   []p2p.FileEvent      // incoming file offers waiting for an answer
+}
+
+// New returns a session on host, which may be nil when there is no P2P
+// node; sending then only stores messages. Incoming files are accepted
+// once Unlock opens the history.
+func New(host Host, cfg *config.Config) *Session {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	var quiet *quietHours
+	if spec := cfg.Notifications.QuietHours; spec != "" {
+		q, err := parseQuietHours(spec)
+		if err != nil {
+			log.Warn("invalid notifications.quiet_hours, ignored", "value", spec, "err", err)
+		} else {
+			quiet = &q
+		}
+	}
+	return &Session{
+		host:     host,
+		cfg:      cfg,
+		quiet:    quiet,
+		active:   GlobalRoom,
+		activity: make(map[string]int64),
+		unread:   make(map[string]int),
+		muted:    make(map[string]bool),
+		online:   make(map[string]bool),
+		contacts: make(map[string]storage.Contact),
+		typing:   make(map[string]time.Time),
+	}
+}
+
+// Unlock opens the history with the master password, deletes abandoned
+// partial files and starts accepting incoming files. It encrypts the metadata of the history first if the
+// config asks for it. After Lock, it receives the envelopes that arrived
+// meanwhile; a password that cannot open them leaves the session locked.
+func (s *Session) Unlock(storageDir, password string) error {
+	st, err := OpenHistory(storageDir, password)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Privacy.EncryptMetadata {
+		if err := st.EncryptMetadata(context.Background()); err != nil {
+			st.Close()
+			return err
+		}
+	}
+	if err := s.replay(st); err != nil {
+		st.Close()
+		return err
+	}
+	s.expirePartials(st)
+	if s.host != nil {
+		s.host.SetFileStore(fileStore(st, s.cfg.Files.QuotaMB<<20))
+	}
+	return nil
+}
+
+// Close closes the history, if it is open.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	st := s.store
+	s.store = nil
+	s.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+	return st.Close()
+}
+
+// OpenHistory derives the database key from the master password and opens
+// the encrypted history under storageDir.
+func OpenHistory(storageDir, password string) (*storage.Store, error) {
+	salt := []byte("shellchat-static-salt")
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	hexKey := fmt.Sprintf("x'%x'", key)
+	clear(key)
+	return storage.Open(storageDir, hexKey)
+}
+
+// Shows reports whether a change in peer's conversation is visible while
+// active is open.
+func Shows(active, peer string) bool {
+	return active == peer || active == GlobalRoom
+}
+
+// Conversations returns the global room followed by every peer seen, most
+// recently active first.
+func (s *Session) Conversations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := append([]string(nil), s.peers...)
+	sort.SliceStable(peers, func(i, j int) bool { return s.activity[peers[i]] > s.activity[peers[j]] })
+	return append([]string{GlobalRoom}, peers...)
+}
+
+// LoadConversations reads the conversations in the history with their
+// unread counts, and which of them are muted.
+func (s *Session) LoadConversations() error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	convs, err := st.GetConversations(context.Background())
+	if err != nil {
+		return err
+	}
+	muted, err := st.GetMuted(context.Background())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range muted {
+		s.muted[p] = true
+	}
+	for _, c := range convs {
+		s.addPeer(c.PeerID)
+		s.activity[c.PeerID] = max(s.activity[c.PeerID], c.LastActivity)
+		if c.PeerID != s.active {
+			s.unread[c.PeerID] = c.Unread
+		}
+	}
+	return nil
+}
+
+// Open records that the user is looking at peer's conversation and marks it
+// read.
+func (s *Session) Open(peer string) {
+	s.mu.Lock()
+	s.active = peer
+	delete(s.unread, peer)
+	s.mu.Unlock()
+	s.markRead(peer)
+}
+
+func (s *Session) markRead(peer string) {
+	st, err := s.history()
+	if err != nil {
+		return
+	}
+	if err := st.MarkRead(context.Background(), peer); err != nil {
+		log.Error("failed to mark conversation read", "peer", peer, "err", err)
+	}
+}
+
+// Unread returns the number of unread messages from peer.
+func (s *Session) Unread(peer string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unread[peer]
+}
+
+// NextUnread returns the most recently active conversation with unread
+// messages.
+func (s *Session) NextUnread() (string, bool) {
+	for _, p := range s.Conversations() {
+		if s.Unread(p) > 0 {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// received counts a message from peer that arrived at t. It reports whether
+// the user is reading that conversation. Callers hold s.mu.
+func (s *Session) received(peer string, t time.Time) bool {
+	s.addPeer(peer)
+	s.activity[peer] = t.Unix()
+	if peer == s.active {
+		return true
+	}
+	s.unread[peer]++
+	return false
+}
+
+// sent records activity in peer's conversation.
+func (s *Session) sent(peer string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activity[peer] = t.Unix()
+}
+
+// Clear deletes the whole history on this device.
+func (s *Session) Clear() error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	if err := st.ClearHistory(context.Background()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.activity)
+	clear(s.unread)
+	return nil
+}
+
+// AddConversation makes sure there is a conversation with peer and reports
+// whether it is new.
+func (s *Session) AddConversation(peer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addPeer(peer)
+}
+
+func (s *Session) addPeer(peer string) bool {
+	if peer == GlobalRoom {
+		return false
+	}
+	for _, p := range s.peers {
+		if p == peer {
+			return false
+		}
+	}
+	s.peers = append(s.peers, peer)
+	return true
+}
+
+// Online reports whether a connection to peer is open.
+func (s *Session) Online(peer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.online[peer]
+}
+
+// HandlePeerEvent keeps conversations and online state current.
+func (s *Session) HandlePeerEvent(e p2p.PeerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e.Kind {
+	case p2p.EventPeerDiscovered:
+		s.addPeer(e.Peer)
+	case p2p.EventPeerConnected:
+		s.addPeer(e.Peer)
+		s.online[e.Peer] = true
+	case p2p.EventPeerDisconnected:
+		delete(s.online, e.Peer)
+	}
+}
+
+// LoadContacts reads the contacts stored in earlier sessions. Presence that
+// arrived before unlock is newer than the stored one and is kept.
+func (s *Session) LoadContacts() error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	contacts, err := st.GetContacts(context.Background())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range contacts {
+		if _, ok := s.contacts[c.PeerID]; !ok {
+			s.contacts[c.PeerID] = c
+		}
+	}
+	return nil
+}
+
+// Contact returns what we know about peer.
+func (s *Session) Contact(peer string) storage.Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contacts[peer]
+}
+
+// SetNickname names peer for display. An empty nickname removes the name.
+func (s *Session) SetNickname(peer, nickname string) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	if err := st.SetNickname(context.Background(), peer, nickname); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.contacts[peer]
+	c.PeerID = peer
+	c.Nickname = nickname
+	s.contacts[peer] = c
+	return nil
+}
+
+// FindPeer returns the known peer that name names, by Peer ID or, ignoring
+// case, by nickname.
+func (s *Session) FindPeer(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.contacts[name]; ok || slices.Contains(s.peers, name) {
+		return name, true
+	}
+	for p, c := range s.contacts {
+		if c.Nickname != "" && strings.EqualFold(c.Nickname, name) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// Contacts returns the peer IDs of every known contact, sorted.
+func (s *Session) Contacts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]string, 0, len(s.contacts))
+	for p := range s.contacts {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Typing returns the peers typing in the active conversation, sorted, and
+// forgets notices older than TypingTimeout.
+func (s *Session) Typing(active string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []string
+	for p, at := range s.typing {
+		if time.Since(at) >= TypingTimeout {
+			delete(s.typing, p)
+			continue
+		}
+		if Shows(active, p) {
+			peers = append(peers, p)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Messages loads the latest messages of a conversation, oldest first.
+func (s *Session) Messages(peer string) ([]storage.Message, error) {
+	st, err := s.history()
+	if err != nil {
+		return nil, err
+	}
+	return st.GetMessages(context.Background(), peer, HistoryLimit)
+}
+
+// Thread loads the reply thread containing msgID, oldest first.
+func (s *Session) Thread(msgID string) ([]storage.Message, error) {
+	st, err := s.history()
+	if err != nil {
+		return nil, err
+	}
+	return st.GetThread(context.Background(), msgID)
+}
+
+// Edits loads the earlier versions of a message, oldest first.
+func (s *Session) Edits(messageID int64) ([]storage.Edit, error) {
+	st, err := s.history()
+	if err != nil {
+		return nil, err
+	}
+	return st.GetEdits(context.Background(), messageID)
+}
+
+// ExportFile decrypts a received file to dst, which must not exist yet.
+func (s *Session) ExportFile(id, dst string) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	return st.ExportFile(id, dst)
+}
+
+// DecryptFile writes the plaintext of a received file to w.
+func (s *Session) DecryptFile(id string, w io.Writer) error {
+	st, err := s.history()
+	if err != nil {
+		return err
+	}
+	return st.DecryptFile(id, w)
+}
+
+// Status returns our presence state and status text.
+func (s *Session) Status() (state, text string) {
+	if s.host == nil {
+		return p2p.PresenceOnline, ""
+	}
+	return s.host.Presence()
+}
+
+// SetStatus handles the argument of /status: an optional state followed
+// by status text. It returns the new status.
+func (s *Session) SetStatus(arg string) (state, text string) {
+	if s.host == nil {
+		return s.Status()
+	}
+	current, _ := s.host.Presence()
+	state, text = p2p.ParseStatus(arg, current)
+	s.host.SetPresence(state, text)
+	return state, text
+}
+
+// SetPresence changes the presence state and keeps the status text.
+func (s *Session) SetPresence(state string) {
+	if s.host == nil {
+		return
+	}
+	_, text := s.host.Presence()
+	s.host.SetPresence(state, text)
+}
@@ -0,0 +1,540 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shellchat/config"
+	"shellchat/p2p"
+)
+
+// fakeHost records what a Session sends instead of talking to peers.
+type fakeHost struct {
+	peers []string
+	sent  chan p2p.Envelope
+
+	mu       sync.Mutex
+	state    string
+	text     string
+	typingTo []string
+	store    p2p.FileStore
+	answers  []string // "peer/id accept" per AnswerOffer
+}
+
+func newFakeHost(peers ...string) *fakeHost {
+	return &fakeHost{peers: peers, sent: make(chan p2p.Envelope, 16), state: p2p.PresenceOnline}
+}
+
+func (h *fakeHost) Send(_ context.Context, _ string, env p2p.Envelope) error {
+	h.sent <- env
+	return nil
+}
+
+func (h *fakeHost) ChatPeers() []string { return h.peers }
+
+func (h *fakeHost) Presence() (string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state, h.text
+}
+
+func (h *fakeHost) SetPresence(state, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state, h.text = state, text
+}
+
+func (h *fakeHost) NotifyTyping(peers ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.typingTo = append(h.typingTo, peers...)
+}
+
+func (h *fakeHost) SetFileStore(store p2p.FileStore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.store = store
+}
+
+func (h *fakeHost) AnswerOffer(peerID, id string, accept bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.answers = append(h.answers, fmt.Sprintf("%s/%s %t", peerID, id, accept))
+	return nil
+}
+
+// expectSent waits for the envelope broadcast sends from a goroutine.
+func (h *fakeHost) expectSent(t *testing.T) p2p.Envelope {
+	t.Helper()
+	select {
+	case env := <-h.sent:
+		return env
+	case <-time.After(time.Second):
+		t.Fatal("nothing sent")
+		return p2p.Envelope{}
+	}
+}
+
+// newTestSession returns an unlocked session on a fresh history.
+func newTestSession(t *testing.T, host *fakeHost, cfg *config.Config) *Session {
+	t.Helper()
+	s := New(host, cfg)
+	if err := s.Unlock(t.TempDir(), "secret"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestUnlockSetsFileStore(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost()
+	newTestSession(t, host, nil)
+	if host.store == nil {
+		t.Fatal("file store not set after unlock")
+	}
+}
+
+func TestSendStoresAndBroadcasts(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost("peerA")
+	s := newTestSession(t, host, nil)
+
+	if err := s.Send(GlobalRoom, "", "hello"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	env := host.expectSent(t)
+	if env.Type != p2p.EnvMessage || env.Body != "hello" || env.ID == "" {
+		t.Errorf("sent %+v, want a message with an ID", env)
+	}
+
+	msgs, err := s.Messages(GlobalRoom)
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if len(msgs) != 1 || !msgs[0].IsSent || msgs[0].MsgID != env.ID {
+		t.Errorf("stored %+v, want our message %s", msgs, env.ID)
+	}
+}
+
+func TestReceiveMessage(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+
+	s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvTyping}})
+	if got := s.Typing(GlobalRoom); len(got) != 1 {
+		t.Fatalf("Typing = %v, want peerA", got)
+	}
+
+	ev, ok := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: "m1", Body: "hi"}})
+	if !ok || ev.Kind != EventMessage || ev.Peer != "peerA" {
+		t.Fatalf("Receive = %+v, %v", ev, ok)
+	}
+	if got := s.Typing(GlobalRoom); len(got) != 0 {
+		t.Errorf("Typing = %v after a message, want none", got)
+	}
+	if got := s.Conversations(); len(got) != 2 || got[1] != "peerA" {
+		t.Errorf("Conversations = %v, want the global room and peerA", got)
+	}
+	msgs, err := s.Messages("peerA")
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].IsSent || msgs[0].Content != "hi" {
+		t.Errorf("stored %+v, want the received message", msgs)
+	}
+}
+
+func TestEditFromOtherPeerIgnored(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+	s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: "m1", Body: "hi"}})
+
+	edit := p2p.Envelope{Type: p2p.EnvEdit, Target: "m1", Body: "forged"}
+	if _, ok := s.Receive(p2p.Incoming{Peer: "peerB", Envelope: edit}); ok {
+		t.Error("edit from another peer was applied")
+	}
+	edit.Body = "hello"
+	if ev, ok := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: edit}); !ok || ev.Kind != EventChanged {
+		t.Errorf("edit from the author: %+v, %v", ev, ok)
+	}
+
+	msgs, _ := s.Messages("peerA")
+	if len(msgs) != 1 || msgs[0].Content != "hello" {
+		t.Errorf("stored %+v, want the author's edit", msgs)
+	}
+}
+
+func TestReactToggles(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost("peerA")
+	s := newTestSession(t, host, nil)
+	if err := s.Send(GlobalRoom, "", "hello"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	id := host.expectSent(t).ID
+
+	added, err := s.React(id, "👍")
+	if err != nil || !added {
+		t.Fatalf("React = %v, %v; want added", added, err)
+	}
+	if env := host.expectSent(t); env.Type != p2p.EnvReaction || env.Remove {
+		t.Errorf("sent %+v, want an added reaction", env)
+	}
+	added, err = s.React(id, "👍")
+	if err != nil || added {
+		t.Fatalf("second React = %v, %v; want removed", added, err)
+	}
+	if env := host.expectSent(t); !env.Remove {
+		t.Errorf("sent %+v, want the reaction removed", env)
+	}
+
+	if _, err := s.React(id, "two words"); err == nil {
+		t.Error("React accepted text with spaces")
+	}
+}
+
+func TestTypingDisabled(t *testing.T) {
+	t.Parallel()
+	cfg := config.Default()
+	cfg.Privacy.TypingIndicators = false
+	host := newFakeHost("peerA")
+	s := newTestSession(t, host, cfg)
+
+	if _, ok := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvTyping}}); ok {
+		t.Error("typing notice shown with indicators disabled")
+	}
+	s.NotifyTyping(GlobalRoom, "hel")
+	if len(host.typingTo) != 0 {
+		t.Errorf("sent typing notices to %v with indicators disabled", host.typingTo)
+	}
+}
+
+func TestNotifyTyping(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost("peerA", "peerB")
+	s := New(host, nil)
+
+	s.NotifyTyping(GlobalRoom, "/status")
+	if len(host.typingTo) != 0 {
+		t.Errorf("commands sent typing notices to %v", host.typingTo)
+	}
+	s.NotifyTyping(GlobalRoom, "hel")
+	if len(host.typingTo) != 2 {
+		t.Errorf("global room notified %v, want every peer", host.typingTo)
+	}
+}
+
+func TestPresenceUpdatesContact(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+
+	ev, ok := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvPresence, Presence: p2p.PresenceAway, Status: "lunch"}})
+	if !ok || ev.Kind != EventPresence {
+		t.Fatalf("Receive = %+v, %v", ev, ok)
+	}
+	if c := s.Contact("peerA"); c.Presence != p2p.PresenceAway || c.StatusText != "lunch" {
+		t.Errorf("Contact = %+v", c)
+	}
+
+	// Stored presence must not replace what arrived this session
+	if err := s.store.SavePresence(context.Background(), "peerA", p2p.PresenceDND, "", 1); err != nil {
+		t.Fatalf("SavePresence: %v", err)
+	}
+	if err := s.LoadContacts(); err != nil {
+		t.Fatalf("LoadContacts: %v", err)
+	}
+	if c := s.Contact("peerA"); c.Presence != p2p.PresenceAway {
+		t.Errorf("LoadContacts replaced live presence: %+v", c)
+	}
+}
+
+func TestSetNickname(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+	s.AddConversation("peerA")
+
+	if err := s.SetNickname("peerA", "alice"); err != nil {
+		t.Fatalf("SetNickname: %v", err)
+	}
+	if p, ok := s.FindPeer("Alice"); !ok || p != "peerA" {
+		t.Errorf("FindPeer(Alice) = %q, %v", p, ok)
+	}
+	if p, ok := s.FindPeer("peerA"); !ok || p != "peerA" {
+		t.Errorf("FindPeer(peerA) = %q, %v", p, ok)
+	}
+	if _, ok := s.FindPeer("bob"); ok {
+		t.Error("FindPeer found a peer nobody named bob")
+	}
+
+	// The nickname is stored, not only kept for this session
+	contacts, err := s.store.GetContacts(context.Background())
+	if err != nil {
+		t.Fatalf("GetContacts: %v", err)
+	}
+	if len(contacts) != 1 || contacts[0].Nickname != "alice" {
+		t.Errorf("stored contacts = %+v", contacts)
+	}
+
+	if err := s.SetNickname("peerA", ""); err != nil {
+		t.Fatalf("SetNickname: %v", err)
+	}
+	if _, ok := s.FindPeer("alice"); ok {
+		t.Error("FindPeer still finds a removed nickname")
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost()
+	s := New(host, nil)
+
+	if state, text := s.SetStatus("dnd focusing"); state != p2p.PresenceDND || text != "focusing" {
+		t.Errorf("SetStatus = %q, %q", state, text)
+	}
+	s.SetPresence(p2p.PresenceAway)
+	if state, text := s.Status(); state != p2p.PresenceAway || text != "focusing" {
+		t.Errorf("Status = %q, %q; want away keeping the text", state, text)
+	}
+}
+
+func TestUnreadAndOrdering(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+	receive := func(peer, id string) {
+		s.Receive(p2p.Incoming{Peer: peer, Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: id, Body: "hi"}})
+	}
+
+	s.AddConversation("peerA")
+	s.AddConversation("peerB")
+	receive("peerB", "m1")
+	receive("peerB", "m2")
+	if n := s.Unread("peerB"); n != 2 {
+		t.Errorf("Unread(peerB) = %d, want 2", n)
+	}
+	if got := s.Conversations(); got[1] != "peerB" {
+		t.Errorf("Conversations = %v, want peerB first after the global room", got)
+	}
+	if p, ok := s.NextUnread(); !ok || p != "peerB" {
+		t.Errorf("NextUnread = %q, %v", p, ok)
+	}
+
+	s.Open("peerB")
+	receive("peerB", "m3")
+	if n := s.Unread("peerB"); n != 0 {
+		t.Errorf("Unread(peerB) = %d while open, want 0", n)
+	}
+	if _, ok := s.NextUnread(); ok {
+		t.Error("NextUnread found a conversation with everything read")
+	}
+
+	// The read marker is stored, so a new session starts with the same counts
+	receive("peerA", "m4")
+	fresh := New(nil, nil)
+	fresh.store = s.store
+	if err := fresh.LoadConversations(); err != nil {
+		t.Fatalf("LoadConversations: %v", err)
+	}
+	if a, b := fresh.Unread("peerA"), fresh.Unread("peerB"); a != 1 || b != 0 {
+		t.Errorf("loaded unread peerA=%d peerB=%d, want 1 and 0", a, b)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost()
+	s := newTestSession(t, host, nil)
+	ev, _ := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: "m1", Body: "secret plans"}})
+	noon := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+
+	n, ok := s.Notification(ev, true, noon)
+	if !ok || n.Body != "New message" {
+		t.Errorf("Notification = %+v, %v; want one hiding the text", n, ok)
+	}
+
+	s.Open("peerA")
+	if _, ok := s.Notification(ev, true, noon); ok {
+		t.Error("notified for the open conversation while focused")
+	}
+	if _, ok := s.Notification(ev, false, noon); !ok {
+		t.Error("no notification while in the background")
+	}
+
+	if muted, err := s.ToggleMute("peerA"); err != nil || !muted {
+		t.Fatalf("ToggleMute = %v, %v", muted, err)
+	}
+	if _, ok := s.Notification(ev, false, noon); ok {
+		t.Error("notified for a muted conversation")
+	}
+	s.ToggleMute("peerA")
+	if _, err := s.ToggleMute(GlobalRoom); err != ErrMuteGlobalRoom {
+		t.Errorf("ToggleMute(GlobalRoom) = %v, want ErrMuteGlobalRoom", err)
+	}
+
+	host.SetPresence(p2p.PresenceDND, "")
+	if _, ok := s.Notification(ev, false, noon); ok {
+		t.Error("notified while dnd")
+	}
+}
+
+func TestNotificationQuietHours(t *testing.T) {
+	t.Parallel()
+	cfg := config.Default()
+	cfg.Notifications.QuietHours = "22:00-07:00"
+	cfg.Notifications.ShowContent = true
+	s := New(nil, cfg)
+	ev := Event{Kind: EventMessage, Peer: "peerA", Text: "hi"}
+
+	for _, tc := range []struct {
+		hour, min int
+		want      bool
+	}{{21, 59, true}, {22, 0, false}, {3, 0, false}, {7, 0, true}} {
+		at := time.Date(2026, 1, 1, tc.hour, tc.min, 0, 0, time.Local)
+		n, ok := s.Notification(ev, false, at)
+		if ok != tc.want {
+			t.Errorf("%02d:%02d: notified = %v, want %v", tc.hour, tc.min, ok, tc.want)
+		}
+		if ok && n.Body != "hi" {
+			t.Errorf("Body = %q, want the message text", n.Body)
+		}
+	}
+}
+
+func TestLockQueuesUntilUnlock(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost()
+	dir := t.TempDir()
+	s := New(host, nil)
+	if err := s.Unlock(dir, "secret"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if err := s.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if s.store != nil || host.store != nil || !s.Locked() {
+		t.Fatal("Lock left the history open")
+	}
+	if _, ok := s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: "m1", Body: "hi"}}); ok {
+		t.Error("message received while locked")
+	}
+
+	if err := s.Unlock(dir, "wrong"); err != ErrWrongPassword {
+		t.Fatalf("Unlock with the wrong password = %v, want ErrWrongPassword", err)
+	}
+	if s.store != nil || !s.Locked() {
+		t.Fatal("wrong password unlocked the history")
+	}
+
+	if err := s.Unlock(dir, "secret"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if s.Locked() || host.store == nil {
+		t.Error("session still locked after Unlock")
+	}
+	msgs, err := s.Messages("peerA")
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "hi" {
+		t.Errorf("stored %+v, want the message queued while locked", msgs)
+	}
+	if s.Unread("peerA") != 1 {
+		t.Errorf("Unread = %d, want 1", s.Unread("peerA"))
+	}
+}
+
+func TestUnlockTwiceClosesOldHistory(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s := New(newFakeHost(), nil)
+	if err := s.Unlock(dir, "secret"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	old := s.store
+	if err := s.Unlock(dir, "secret"); err != nil {
+		t.Fatalf("second Unlock: %v", err)
+	}
+	if s.store == old {
+		t.Fatal("second Unlock kept the old history")
+	}
+	if _, err := old.Encrypt("hello"); err == nil {
+		t.Error("old history still open")
+	}
+}
+
+func TestUnlockEncryptsMetadata(t *testing.T) {
+	t.Parallel()
+	cfg := config.Default()
+	cfg.Privacy.EncryptMetadata = true
+	s := newTestSession(t, newFakeHost(), cfg)
+	if !s.store.MetadataEncrypted() {
+		t.Fatal("metadata not encrypted after Unlock")
+	}
+	s.Receive(p2p.Incoming{Peer: "peerA", Envelope: p2p.Envelope{Type: p2p.EnvMessage, ID: "m1", Body: "hi"}})
+	msgs, err := s.Messages("peerA")
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].PeerID != "peerA" || msgs[0].Timestamp == 0 {
+		t.Errorf("Messages = %+v", msgs)
+	}
+}
+
+func TestFileOffers(t *testing.T) {
+	t.Parallel()
+	host := newFakeHost()
+	s := newTestSession(t, host, nil)
+
+	offer := func(id, name string) p2p.FileEvent {
+		return p2p.FileEvent{FileOffer: p2p.FileOffer{ID: id, Name: name, Size: 10}, Peer: "peerA", Incoming: true, Offered: true}
+	}
+	s.ReceiveFile(offer("id1", "a.txt"))
+	s.ReceiveFile(offer("id2", "b.txt"))
+	if e, ok := s.Offer(""); !ok || e.ID != "id2" {
+		t.Fatalf("Offer(\"\") = %+v, %t, want the newest", e, ok)
+	}
+	e, ok := s.Offer("a.txt")
+	if !ok || e.ID != "id1" {
+		t.Fatalf("Offer(a.txt) = %+v, %t", e, ok)
+	}
+	if err := s.AnswerOffer(e, true); err != nil {
+		t.Fatalf("AnswerOffer: %v", err)
+	}
+	if len(host.answers) != 1 || host.answers[0] != "peerA/id1 true" {
+		t.Errorf("answers = %v", host.answers)
+	}
+
+	// An offer that expires unanswered ends like a failed transfer
+	s.ReceiveFile(p2p.FileEvent{FileOffer: offer("id2", "b.txt").FileOffer, Peer: "peerA", Incoming: true, Finished: true, Err: p2p.ErrOfferDeclined})
+	if got := s.Offers(); len(got) != 0 {
+		t.Errorf("Offers = %+v, want none left", got)
+	}
+}
+
+func TestFileQuota(t *testing.T) {
+	t.Parallel()
+	s := newTestSession(t, newFakeHost(), nil)
+	store := fileStore(s.store, 100)
+	id1, id2 := strings.Repeat("a", 64), strings.Repeat("b", 64)
+
+	first, err := store(id1, 80)
+	if err != nil {
+		t.Fatalf("first file: %v", err)
+	}
+	if _, err := store(id2, 50); err == nil {
+		t.Fatal("second file accepted past the quota")
+	}
+	first.Close()
+	second, err := store(id2, 50)
+	if err != nil {
+		t.Fatalf("second file after the first ended: %v", err)
+	}
+	second.Close()
+}
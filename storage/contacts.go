@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Contact is what we know about a peer besides its messages.
+// Nickname and StatusText are encrypted at rest like message content.
+type Contact struct {
+	PeerID     string
+	Nickname   string
+	Presence   string
+	StatusText string
+	UpdatedAt  int64 // zero when metadata is encrypted
+}
+
+// SavePresence records the presence state and status text a peer published.
+func (s *Store) SavePresence(ctx context.Context, peerID, presence, statusText string, updatedAt int64) error {
+	encryptedStatus, err := s.encryptOptional(statusText)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt status: %w", err)
+	}
+
+	peer, err := s.addPeer(ctx, s.db, peerID)
+	if err != nil {
+		return err
+	}
+	if s.MetadataEncrypted() {
+		updatedAt = 0
+	}
+
+	query := `
+		INSERT INTO contacts (peer_id, presence, status_text, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(peer_id) DO UPDATE SET
+			presence = excluded.presence,
+			status_text = excluded.status_text,
+			updated_at = excluded.updated_at`
+	if _, err := s.db.ExecContext(ctx, query, peer, presence, encryptedStatus, updatedAt); err != nil {
+		return fmt.Errorf("failed to save presence: %w", err)
+	}
+	return nil
+}
+
+// SetNickname names a peer for display. An empty nickname removes it.
+func (s *Store) SetNickname(ctx context.Context, peerID, nickname string) error {
+	encryptedNick, err := s.encryptOptional(nickname)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt nickname: %w", err)
+	}
+
+	peer, err := s.addPeer(ctx, s.db, peerID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO contacts (peer_id, nickname) VALUES (?, ?)
+		ON CONFLICT(peer_id) DO UPDATE SET nickname = excluded.nickname`
+	if _, err := s.db.ExecContext(ctx, query, peer, encryptedNick); err != nil {
+		return fmt.Errorf("failed to save nickname: %w", err)
+	}
+	return nil
+}
+
+// GetContacts returns every stored contact.
+func (s *Store) GetContacts(ctx context.Context) ([]Contact, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT peer_id, nickname, presence, status_text, updated_at FROM contacts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contacts: %w", err)
+	}
+	defer rows.Close()
+
+	var contacts []Contact
+	for rows.Next() {
+		var c Contact
+		if err := rows.Scan(&c.PeerID, &c.Nickname, &c.Presence, &c.StatusText, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if c.Nickname, err = s.decryptOptional(c.Nickname); err != nil {
+			log.Warn("failed to decrypt nickname", "peer", c.PeerID, "err", err)
+			c.Nickname = ""
+		}
+		if c.StatusText, err = s.decryptOptional(c.StatusText); err != nil {
+			log.Warn("failed to decrypt status", "peer", c.PeerID, "err", err)
+			c.StatusText = ""
+		}
+		contacts = append(contacts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range contacts {
+		contacts[i].PeerID = s.peerID(ctx, contacts[i].PeerID)
+	}
+	return contacts, nil
+}
+
+// encryptOptional leaves empty strings empty so "not set" stays visible
+// without a key.
+func (s *Store) encryptOptional(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	return s.Encrypt(text)
+}
+
+func (s *Store) decryptOptional(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	return s.Decrypt(text)
+}
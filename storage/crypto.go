@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
@@ -10,12 +11,6 @@ import (
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
-var (
-	// SessionKey is the derived key used for encryption/decryption during this session.
-	// It is kept in memory and never stored on disk.
-	SessionKey []byte
-)
-
 const (
 	KeySize  = 32
 	SaltSize = 16
@@ -35,14 +30,20 @@ func DeriveKey(password string, salt []byte) []byte {
 	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, KeySize)
 }
 
-// Encrypt encrypts plaintext using XChaCha20-Poly1305.
-// Returns the nonce appended with the ciphertext, Base64 encoded.
-func Encrypt(plaintext string) (string, error) {
-	if len(SessionKey) != KeySize {
-		return "", errors.New("encryption key not initialized")
+// aead returns the XChaCha20-Poly1305 cipher of the store's key.
+func (s *Store) aead() (cipher.AEAD, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.key) != KeySize {
+		return nil, errors.New("encryption key not initialized")
 	}
+	return chacha20poly1305.NewX(s.key)
+}
 
-	aead, err := chacha20poly1305.NewX(SessionKey)
+// Encrypt encrypts plaintext using XChaCha20-Poly1305.
+// Returns the nonce appended with the ciphertext, Base64 encoded.
+func (s *Store) Encrypt(plaintext string) (string, error) {
+	aead, err := s.aead()
 	if err != nil {
 		return "", err
 	}
@@ -57,17 +58,13 @@ func Encrypt(plaintext string) (string, error) {
 }
 
 // Decrypt decrypts a Base64 encoded ciphertext using XChaCha20-Poly1305.
-func Decrypt(encodedCiphertext string) (string, error) {
-	if len(SessionKey) != KeySize {
-		return "", errors.New("encryption key not initialized")
-	}
-
-	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+func (s *Store) Decrypt(encodedCiphertext string) (string, error) {
+	aead, err := s.aead()
 	if err != nil {
 		return "", err
 	}
 
-	aead, err := chacha20poly1305.NewX(SessionKey)
+	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
 	if err != nil {
 		return "", err
 	}
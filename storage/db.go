@@ -3,24 +3,44 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	_ "modernc.org/sqlite"
 )
 
-var DB *sql.DB
+// Store is one encrypted history: the database, the key derived from the
+// master password and the directory of received files. It is safe for
+// concurrent use; after Close every method fails.
+type Store struct {
+	db *sql.DB
 
-// InitDB initializes the SQLite database.
-func InitDB(storageDir, password string) error {
+	// filesDir holds received files, encrypted, named by their SHA-256.
+	filesDir string
+
+	mu       sync.RWMutex
+	key      []byte            // derived from the password; never stored on disk
+	indexKey []byte            // nil unless metadata is encrypted; see metadata.go
+	peers    map[string]string // peer index -> peer ID, read back so far
+}
+
+// Open opens, or creates, the history under storageDir with password.
+func Open(storageDir, password string) (*Store, error) {
 	if password == "" {
-		return fmt.Errorf("password cannot be empty")
+		return nil, fmt.Errorf("password cannot be empty")
 	}
 
 	appDir := filepath.Join(storageDir, "shellchat")
 	if err := os.MkdirAll(appDir, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	filesDir := filepath.Join(appDir, "files")
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
 	}
 
 	dbPath := filepath.Join(appDir, "shellchat.db")
@@ -30,53 +50,54 @@ func InitDB(storageDir, password string) error {
 
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	s := &Store{db: db, filesDir: filesDir}
+
+	ctx := context.Background()
+	if err := s.init(ctx, password); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return s, nil
+}
 
+func (s *Store) init(ctx context.Context, password string) error {
 	// Verify connection
-	if err := db.Ping(); err != nil {
+	if err := s.db.PingContext(ctx); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	// Enable WAL mode for better concurrency
-	if _, err := db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
+	if _, err := s.db.ExecContext(ctx, "PRAGMA journal_mode=WAL;"); err != nil {
 		return fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	DB = db
-
 	// Initialize Schema (messages table)
-	if err := createSchema(context.Background()); err != nil {
+	if err := s.createSchema(ctx); err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
 	// Initialize Encryption (Salt & Key Derivation)
-	if err := initEncryption(password); err != nil {
+	if err := s.initEncryption(ctx, password); err != nil {
 		return fmt.Errorf("failed to initialize encryption: %w", err)
 	}
-
 	return nil
 }
 
 // initEncryption handles the salt and key derivation
-func initEncryption(password string) error {
-	// Create metadata table if not exists (for salt)
-	_, err := DB.Exec(`CREATE TABLE IF NOT EXISTS metadata (key TEXT PRIMARY KEY, value BLOB)`)
-	if err != nil {
-		return fmt.Errorf("failed to create metadata table: %w", err)
-	}
-
+func (s *Store) initEncryption(ctx context.Context, password string) error {
 	// Check if salt exists
 	var salt []byte
-	err = DB.QueryRow("SELECT value FROM metadata WHERE key = 'salt'").Scan(&salt)
-	if err == sql.ErrNoRows {
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM metadata WHERE key = 'salt'").Scan(&salt)
+	if errors.Is(err, sql.ErrNoRows) {
 		// New database: Generate new salt
 		salt, err = GenerateSalt()
 		if err != nil {
 			return fmt.Errorf("failed to generate salt: %w", err)
 		}
 		// Store salt
-		_, err = DB.Exec("INSERT INTO metadata (key, value) VALUES ('salt', ?)", salt)
+		_, err = s.db.ExecContext(ctx, "INSERT INTO metadata (key, value) VALUES ('salt', ?)", salt)
 		if err != nil {
 			return fmt.Errorf("failed to store salt: %w", err)
 		}
@@ -85,14 +106,18 @@ func initEncryption(password string) error {
 	}
 
 	// Derive session key
-	SessionKey = DeriveKey(password, salt)
-	return nil
+	s.mu.Lock()
+	s.key = DeriveKey(password, salt)
+	s.mu.Unlock()
+	return s.initMetadata(ctx)
 }
 
-// CloseDB closes the database connection.
-func CloseDB() error {
-	if DB != nil {
-		return DB.Close()
-	}
-	return nil
+// Close zeroes the key and closes the database.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	clear(s.key)
+	clear(s.indexKey)
+	s.key, s.indexKey, s.peers = nil, nil, nil
+	s.mu.Unlock()
+	return s.db.Close()
 }
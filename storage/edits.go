@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when an edit or delete names a message that does
+// not exist or was not written by whoever asked for the change.
+var ErrNotFound = errors.New("message not found")
+
+// Edit is an earlier version of an edited message.
+type Edit struct {
+	Content    string
+	ReplacedAt int64
+}
+
+// EditMessage replaces the content of message msgID, keeping the previous
+// version in message_edits. Received messages can only be changed by the
+// peer that sent them, so fromPeer must match for those; for our own
+// messages (isSent) it is ignored.
+func (s *Store) EditMessage(ctx context.Context, msgID, fromPeer string, isSent bool, content string, editedAt int64) error {
+	encryptedContent, err := s.Encrypt(content)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	replacedAt, sealedAt, err := s.sealTime(editedAt)
+	if err != nil {
+		return err
+	}
+	if sealedAt != "" {
+		editedAt = 1 // only flags the edit; the edit history has the time
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, oldContent, err := s.findAuthored(ctx, tx, msgID, fromPeer, isSent)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO message_edits (message_id, content, replaced_at, sealed_at) VALUES (?, ?, ?, ?)`, id, oldContent, replacedAt, sealedAt); err != nil {
+		return fmt.Errorf("failed to save edit history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, encryptedContent, editedAt, id); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	return tx.Commit()
+}
+
+// DeleteMessage blanks message msgID and drops its edit history and
+// reactions, leaving a row that renders as "message deleted". The author
+// rules of EditMessage apply.
+func (s *Store) DeleteMessage(ctx context.Context, msgID, fromPeer string, isSent bool) error {
+	empty, err := s.Encrypt("")
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, _, err := s.findAuthored(ctx, tx, msgID, fromPeer, isSent)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_edits WHERE message_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete edit history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reactions WHERE msg_id = ?`, msgID); err != nil {
+		return fmt.Errorf("failed to delete reactions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET content = ?, deleted = 1 WHERE id = ?`, empty, id); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetEdits returns the earlier versions of a message, oldest first.
+func (s *Store) GetEdits(ctx context.Context, messageID int64) ([]Edit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT content, replaced_at, sealed_at FROM message_edits WHERE message_id = ? ORDER BY replaced_at, rowid`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query edits: %w", err)
+	}
+	defer rows.Close()
+
+	var edits []Edit
+	for rows.Next() {
+		var e Edit
+		var sealedAt string
+		if err := rows.Scan(&e.Content, &e.ReplacedAt, &sealedAt); err != nil {
+			return nil, err
+		}
+		e.ReplacedAt = s.openTime(e.ReplacedAt, sealedAt)
+		if e.Content, err = s.Decrypt(e.Content); err != nil {
+			log.Warn("failed to decrypt edit", "message", messageID, "err", err)
+			e.Content = fmt.Sprintf("[Decryption Failed: %v]", err)
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// findAuthored looks up a live message by its shared ID and author.
+func (s *Store) findAuthored(ctx context.Context, tx *sql.Tx, msgID, fromPeer string, isSent bool) (int64, string, error) {
+	if msgID == "" {
+		return 0, "", ErrNotFound
+	}
+	query := `SELECT id, content FROM messages WHERE msg_id = ? AND is_sent = ? AND deleted = 0`
+	args := []any{msgID, isSent}
+	if !isSent {
+		query += ` AND peer_id = ?`
+		args = append(args, s.peerIndex(fromPeer))
+	}
+
+	var id int64
+	var content string
+	err := tx.QueryRowContext(ctx, query+` LIMIT 1`, args...).Scan(&id, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", ErrNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to look up message: %w", err)
+	}
+	return id, content, nil
+}
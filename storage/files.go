@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a transferred file. Received files are kept encrypted under the
+// data directory; Stored is false for files we only sent.
+type File struct {
+	ID     string // hex SHA-256 of the content
+	Name   string
+	Size   int64
+	Stored bool
+}
+
+// fileRecordSize is the most plaintext sealed into one record. Files on
+// disk are a sequence of records, each a 4-byte big-endian length followed
+// by nonce and ciphertext, so a partial file can be appended to and a torn
+// last record dropped.
+const fileRecordSize = 64 << 10
+
+// PartialFile is an incoming file being written to disk, encrypted one
+// record at a time. Reopening it picks up after the last complete record.
+type PartialFile struct {
+	f    *os.File
+	aead cipher.AEAD
+	size int64
+	hash hash.Hash
+}
+
+// OpenPartial opens, or creates, the file for transfer id.
+func (s *Store) OpenPartial(id string) (*PartialFile, error) {
+	path, err := s.filePath(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial file: %w", err)
+	}
+
+	p := &PartialFile{f: f, aead: aead, hash: sha256.New()}
+	var good int64
+	for {
+		plain, n, err := readRecord(f, aead)
+		if err != nil {
+			break
+		}
+		p.hash.Write(plain)
+		p.size += int64(len(plain))
+		good += n
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write encrypts b and appends it.
+func (p *PartialFile) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), fileRecordSize)]
+
+		nonce := make([]byte, p.aead.NonceSize(), p.aead.NonceSize()+len(chunk)+p.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return written, err
+		}
+		sealed := p.aead.Seal(nonce, nonce, chunk, nil)
+
+		record := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(sealed)), uint32(len(sealed)))
+		if _, err := p.f.Write(append(record, sealed...)); err != nil {
+			return written, err
+		}
+		p.hash.Write(chunk)
+		p.size += int64(len(chunk))
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// Size returns the number of plaintext bytes written so far.
+func (p *PartialFile) Size() int64 { return p.size }
+
+// Sum returns the SHA-256 of the plaintext written so far.
+func (p *PartialFile) Sum() []byte { return p.hash.Sum(nil) }
+
+// Discard closes and deletes the file.
+func (p *PartialFile) Discard() error {
+	p.f.Close()
+	return os.Remove(p.f.Name())
+}
+
+func (p *PartialFile) Close() error { return p.f.Close() }
+
+// ExportFile decrypts a stored file to dst, which must not exist yet.
+func (s *Store) ExportFile(id, dst string) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := s.DecryptFile(id, out); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptFile writes the plaintext of a stored file to w.
+func (s *Store) DecryptFile(id string, w io.Writer) error {
+	path, err := s.filePath(id)
+	if err != nil {
+		return err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open stored file: %w", err)
+	}
+	defer src.Close()
+
+	for {
+		plain, _, err := readRecord(src, aead)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decrypt stored file: %w", err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+	}
+}
+
+// SaveFileMessage records a transferred file and a message referring to it.
+func (s *Store) SaveFileMessage(ctx context.Context, peerID string, file File, timestamp int64, isSent bool) error {
+	encryptedName, err := s.Encrypt(file.Name)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt file name: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	peer, err := s.addPeer(ctx, tx, peerID)
+	if err != nil {
+		return err
+	}
+	timestamp, sealedAt, err := s.sealTime(timestamp)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO files (id, name, size, stored) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET stored = stored OR excluded.stored`
+	if _, err := tx.ExecContext(ctx, query, file.ID, encryptedName, file.Size, file.Stored); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	// The name doubles as content so the row still reads sensibly on its own
+	query = `INSERT INTO messages (peer_id, content, timestamp, sealed_at, is_sent, file_id) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, peer, encryptedName, timestamp, sealedAt, isSent, file.ID); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	return tx.Commit()
+}
+
+// FilesSize returns the bytes the received files, complete or not, take
+// on disk.
+func (s *Store) FilesSize() (int64, error) {
+	entries, err := os.ReadDir(s.filesDir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// ExpirePartials deletes the incoming files that never completed and have
+// not been written to for maxAge. It returns how many it deleted.
+func (s *Store) ExpirePartials(ctx context.Context, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(s.filesDir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		var stored bool
+		err = s.db.QueryRowContext(ctx, "SELECT stored FROM files WHERE id = ?", e.Name()).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return removed, fmt.Errorf("failed to query file: %w", err)
+		}
+		if stored {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.filesDir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (s *Store) filePath(id string) (string, error) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid file id %q", id)
+	}
+	return filepath.Join(s.filesDir, id), nil
+}
+
+// readRecord decrypts the next record and returns its plaintext and its
+// length on disk.
+func readRecord(r io.Reader, aead cipher.AEAD) ([]byte, int64, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < uint32(aead.NonceSize()+aead.Overhead()) || n > fileRecordSize+uint32(aead.NonceSize()+aead.Overhead()) {
+		return nil, 0, errors.New("corrupt file record")
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plain, int64(4 + n), nil
+}
@@ -1,58 +1,147 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+
+	"shellchat/logging"
 )
 
+var log = logging.Logger("storage")
+
 type Message struct {
 	ID        int64
+	MsgID     string // shared with peers; empty for messages from older versions
 	PeerID    string
 	Content   string
 	Timestamp int64
 	IsSent    bool
+	EditedAt  int64 // zero unless edited; 1 when metadata is encrypted
+	Deleted   bool
+	File      *File  // set for file transfers; Content is then the file name
+	ReplyTo   string // MsgID of the message this one answers
+	Quote     *Quote // the answered message, if we have it
+	Reactions []Reaction
+}
+
+// Quote previews the message a reply answers.
+type Quote struct {
+	Content string // empty when that message was deleted
+	IsSent  bool
 }
 
-// SaveMessage stores a new message in the encrypted database.
-func SaveMessage(peerID, content string, timestamp int64, isSent bool) error {
+// SaveMessage stores a new message in the encrypted database. replyTo is
+// the MsgID of the message it answers, or empty.
+func (s *Store) SaveMessage(ctx context.Context, peerID, msgID, replyTo, content string, timestamp int64, isSent bool) error {
 	// Encrypt content
-	encryptedContent, err := Encrypt(content)
+	encryptedContent, err := s.Encrypt(content)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt message: %w", err)
 	}
 
-	query := `INSERT INTO messages (peer_id, content, timestamp, is_sent) VALUES (?, ?, ?, ?)`
-	_, err = DB.Exec(query, peerID, encryptedContent, timestamp, isSent)
+	peer, err := s.addPeer(ctx, s.db, peerID)
+	if err != nil {
+		return err
+	}
+	timestamp, sealedAt, err := s.sealTime(timestamp)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO messages (peer_id, msg_id, reply_to, content, timestamp, sealed_at, is_sent) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, peer, msgID, replyTo, encryptedContent, timestamp, sealedAt, isSent)
 	if err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 	return nil
 }
 
+// messageColumns is what scanMessages expects, selected from messages m.
+const messageColumns = `
+	m.id, m.msg_id, m.peer_id, m.content, m.timestamp, m.sealed_at, m.is_sent, m.edited_at, m.deleted,
+	m.file_id, f.size, f.stored, m.reply_to,
+	(SELECT q.content FROM messages q WHERE m.reply_to != '' AND q.msg_id = m.reply_to LIMIT 1),
+	(SELECT q.is_sent FROM messages q WHERE m.reply_to != '' AND q.msg_id = m.reply_to LIMIT 1)
+	FROM messages m
+	LEFT JOIN files f ON f.id = m.file_id`
+
 // GetMessages retrieves the last N messages for a specific peer.
-func GetMessages(peerID string, limit int) ([]Message, error) {
+func (s *Store) GetMessages(ctx context.Context, peerID string, limit int) ([]Message, error) {
 	query := `
-		SELECT id, peer_id, content, timestamp, is_sent 
-		FROM messages 
-		WHERE peer_id = ? 
-		ORDER BY timestamp DESC 
+		SELECT ` + messageColumns + `
+		WHERE m.peer_id = ?
+		ORDER BY m.timestamp DESC, m.id DESC
 		LIMIT ?`
 
-	rows, err := DB.Query(query, peerID, limit)
+	rows, err := s.db.QueryContext(ctx, query, s.peerIndex(peerID), limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
 	defer rows.Close()
 
+	messages, err := s.scanMessages(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reverse the slice so oldest is first (for chat UI)
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// GetThread returns every message in the reply thread containing msgID,
+// oldest first: its root and everything that answers it, directly or not.
+func (s *Store) GetThread(ctx context.Context, msgID string) ([]Message, error) {
+	query := `
+		WITH RECURSIVE
+		up(msg_id, reply_to) AS (
+			SELECT msg_id, reply_to FROM messages WHERE msg_id = ?
+			UNION
+			SELECT p.msg_id, p.reply_to FROM messages p JOIN up ON p.msg_id = up.reply_to
+		),
+		down(msg_id) AS (
+			SELECT msg_id FROM up WHERE reply_to = '' OR reply_to NOT IN (SELECT msg_id FROM messages)
+			UNION
+			SELECT c.msg_id FROM messages c JOIN down ON c.reply_to = down.msg_id
+		)
+		SELECT ` + messageColumns + `
+		WHERE m.msg_id != '' AND m.msg_id IN (SELECT msg_id FROM down)
+		ORDER BY m.timestamp, m.id`
+
+	rows, err := s.db.QueryContext(ctx, query, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query thread: %w", err)
+	}
+	defer rows.Close()
+	return s.scanMessages(ctx, rows)
+}
+
+func (s *Store) scanMessages(ctx context.Context, rows *sql.Rows) ([]Message, error) {
 	var messages []Message
 	for rows.Next() {
 		var m Message
-		if err := rows.Scan(&m.ID, &m.PeerID, &m.Content, &m.Timestamp, &m.IsSent); err != nil {
+		var fileID string
+		var fileSize sql.NullInt64
+		var fileStored sql.NullBool
+		var quote sql.NullString
+		var quoteSent sql.NullBool
+		var sealedAt string
+		if err := rows.Scan(&m.ID, &m.MsgID, &m.PeerID, &m.Content, &m.Timestamp, &sealedAt, &m.IsSent, &m.EditedAt, &m.Deleted,
+			&fileID, &fileSize, &fileStored, &m.ReplyTo, &quote, &quoteSent); err != nil {
 			return nil, err
 		}
+		m.Timestamp = s.openTime(m.Timestamp, sealedAt)
 
 		// Decrypt content
-		decryptedContent, err := Decrypt(m.Content)
+		decryptedContent, err := s.Decrypt(m.Content)
 		if err != nil {
+			log.Warn("failed to decrypt message", "id", m.ID, "err", err)
 			// If decryption fails (e.g., wrong password or corrupted data),
 			// we might want to return the raw content or an error indicator.
 			// For now, let's return a placeholder so the UI doesn't crash.
@@ -60,23 +149,56 @@ func GetMessages(peerID string, limit int) ([]Message, error) {
 		} else {
 			m.Content = decryptedContent
 		}
+		if fileID != "" {
+			m.File = &File{ID: fileID, Name: m.Content, Size: fileSize.Int64, Stored: fileStored.Bool}
+		}
+		if quote.Valid {
+			m.Quote = &Quote{IsSent: quoteSent.Bool}
+			if m.Quote.Content, err = s.Decrypt(quote.String); err != nil {
+				m.Quote.Content = "[Decryption Failed]"
+			}
+		}
 
 		messages = append(messages, m)
 	}
-
-	// Reverse the slice so oldest is first (for chat UI)
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	rows.Close()
 
+	for i := range messages {
+		messages[i].PeerID = s.peerID(ctx, messages[i].PeerID)
+	}
+	if err := s.loadReactions(ctx, messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
-// ClearHistory removes all messages from the database.
-func ClearHistory() error {
-	_, err := DB.Exec("DELETE FROM messages")
+// ClearHistory removes all messages, and the files they refer to, from the
+// database.
+func (s *Store) ClearHistory(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM messages")
 	if err != nil {
 		return fmt.Errorf("failed to clear history: %w", err)
 	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM reactions"); err != nil {
+		return fmt.Errorf("failed to clear reactions: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM message_edits"); err != nil {
+		return fmt.Errorf("failed to clear edit history: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM files"); err != nil {
+		return fmt.Errorf("failed to clear files: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM read_markers"); err != nil {
+		return fmt.Errorf("failed to clear read markers: %w", err)
+	}
+	entries, _ := os.ReadDir(s.filesDir)
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(s.filesDir, e.Name())); err != nil {
+			log.Warn("failed to remove stored file", "file", e.Name(), "err", err)
+		}
+	}
 	return nil
 }
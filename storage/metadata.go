@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// With metadata encryption on, the database file no longer shows who we
+// talk to or when. Peer IDs are replaced by a keyed HMAC of them, so equal
+// IDs still match in queries, and each ID is kept encrypted once in the
+// peers table to read it back. Timestamps are encrypted into sealed_at
+// next to the rows they date and the plain columns hold zero, so rows are
+// ordered by insertion. Whether a message was sent or received stays
+// visible.
+
+// metadataFlag marks a history whose metadata is encrypted. It is never
+// turned off again.
+const metadataFlag = "encrypted_metadata"
+
+// ErrWrongKey is returned by EncryptMetadata when the password does not
+// decrypt the history, which would otherwise be migrated with the wrong
+// key.
+var ErrWrongKey = errors.New("the password does not decrypt this history")
+
+// execer runs statements on the database or inside a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// MetadataEncrypted reports whether peer IDs and timestamps are encrypted.
+func (s *Store) MetadataEncrypted() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.indexKey != nil
+}
+
+// initMetadata turns metadata encryption on if the history has it.
+func (s *Store) initMetadata(ctx context.Context) error {
+	var flag string
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM metadata WHERE key = ?", metadataFlag).Scan(&flag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query metadata mode: %w", err)
+	}
+	return s.enableMetadata()
+}
+
+// enableMetadata derives the peer index key from the history key.
+func (s *Store) enableMetadata() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := hkdf.Key(sha256.New, s.key, nil, "shellchat peer index", KeySize)
+	if err != nil {
+		return err
+	}
+	s.indexKey = key
+	s.peers = make(map[string]string)
+	return nil
+}
+
+// EncryptMetadata turns metadata encryption on, migrating the peer IDs and
+// timestamps already stored. It does nothing if it is already on.
+func (s *Store) EncryptMetadata(ctx context.Context) error {
+	if s.MetadataEncrypted() {
+		return nil
+	}
+	if err := s.checkKey(ctx); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO metadata (key, value) VALUES (?, '1')", metadataFlag); err != nil {
+		return fmt.Errorf("failed to store metadata mode: %w", err)
+	}
+	if err := s.enableMetadata(); err != nil {
+		return err
+	}
+	// addPeer needs the key during the migration, so it is set before and
+	// taken back if the migration is not committed
+	err = s.migrateMetadata(ctx, tx)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.indexKey, s.peers = nil, nil
+		s.mu.Unlock()
+		return fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	return nil
+}
+
+// checkKey makes sure the newest message decrypts, if there is one.
+func (s *Store) checkKey(ctx context.Context) error {
+	var content string
+	err := s.db.QueryRowContext(ctx, "SELECT content FROM messages ORDER BY id DESC LIMIT 1").Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %w", err)
+	}
+	if _, err := s.Decrypt(content); err != nil {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// migrateMetadata rewrites plain peer IDs and timestamps in tx.
+func (s *Store) migrateMetadata(ctx context.Context, tx *sql.Tx) error {
+	peerColumns := []struct{ table, column string }{
+		{"messages", "peer_id"},
+		{"contacts", "peer_id"},
+		{"read_markers", "peer_id"},
+		{"muted", "peer_id"},
+		{"reactions", "sender"},
+	}
+	for _, pc := range peerColumns {
+		ids, err := queryStrings(ctx, tx, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s != ''", pc.column, pc.table, pc.column))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			index, err := s.addPeer(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", pc.table, pc.column, pc.column), index, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range []string{"messages", "message_edits"} {
+		column := "timestamp"
+		if table == "message_edits" {
+			column = "replaced_at"
+		}
+		if err := s.sealColumn(ctx, tx, table, column); err != nil {
+			return err
+		}
+	}
+	query := `
+		UPDATE messages SET edited_at = 1 WHERE edited_at != 0;
+		UPDATE reactions SET created_at = 0;
+		UPDATE contacts SET updated_at = 0;`
+	_, err := tx.ExecContext(ctx, query)
+	return err
+}
+
+// sealColumn moves the timestamps in column into sealed_at.
+func (s *Store) sealColumn(ctx context.Context, tx *sql.Tx, table, column string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid, %s FROM %s", column, table))
+	if err != nil {
+		return err
+	}
+	type row struct{ id, at int64 }
+	var all []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.at); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range all {
+		plain, sealed, err := s.sealTime(r.at)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = ?, sealed_at = ? WHERE rowid = ?", table, column), plain, sealed, r.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// peerIndex returns what is stored in place of peer ID id: id itself, or
+// its HMAC when metadata is encrypted. Our own empty sender stays empty.
+func (s *Store) peerIndex(id string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index(id)
+}
+
+// index is peerIndex for callers holding s.mu.
+func (s *Store) index(id string) string {
+	if s.indexKey == nil || id == "" {
+		return id
+	}
+	mac := hmac.New(sha256.New, s.indexKey)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// addPeer returns the index of id and, with metadata encrypted, makes sure
+// the peers table can turn it back into id.
+func (s *Store) addPeer(ctx context.Context, ex execer, id string) (string, error) {
+	s.mu.RLock()
+	index := s.index(id)
+	_, known := s.peers[index]
+	encrypted := s.indexKey != nil
+	s.mu.RUnlock()
+	if !encrypted || known || id == "" {
+		return index, nil
+	}
+
+	sealed, err := s.Encrypt(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt peer: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, "INSERT OR IGNORE INTO peers (id, peer) VALUES (?, ?)", index, sealed); err != nil {
+		return "", fmt.Errorf("failed to save peer: %w", err)
+	}
+	s.mu.Lock()
+	if s.peers != nil {
+		s.peers[index] = id
+	}
+	s.mu.Unlock()
+	return index, nil
+}
+
+// peerID turns a stored peer index back into the peer ID. An index that
+// cannot be read back is returned as it is.
+func (s *Store) peerID(ctx context.Context, index string) string {
+	s.mu.RLock()
+	id, known := s.peers[index]
+	encrypted := s.indexKey != nil
+	s.mu.RUnlock()
+	if known {
+		return id
+	}
+	if !encrypted || index == "" {
+		return index
+	}
+
+	var sealed string
+	if err := s.db.QueryRowContext(ctx, "SELECT peer FROM peers WHERE id = ?", index).Scan(&sealed); err != nil {
+		log.Warn("unknown peer index", "index", index, "err", err)
+		return index
+	}
+	id, err := s.Decrypt(sealed)
+	if err != nil {
+		log.Warn("failed to decrypt peer", "index", index, "err", err)
+		return index
+	}
+	s.mu.Lock()
+	if s.peers != nil {
+		s.peers[index] = id
+	}
+	s.mu.Unlock()
+	return id
+}
+
+// sealTime returns what is stored for timestamp t: t itself, or zero and
+// t encrypted when metadata is encrypted.
+func (s *Store) sealTime(t int64) (int64, string, error) {
+	if !s.MetadataEncrypted() {
+		return t, "", nil
+	}
+	sealed, err := s.Encrypt(strconv.FormatInt(t, 10))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to encrypt timestamp: %w", err)
+	}
+	return 0, sealed, nil
+}
+
+// openTime reverses sealTime.
+func (s *Store) openTime(plain int64, sealed string) int64 {
+	if sealed == "" {
+		return plain
+	}
+	text, err := s.Decrypt(sealed)
+	if err != nil {
+		log.Warn("failed to decrypt timestamp", "err", err)
+		return plain
+	}
+	t, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return plain
+	}
+	return t
+}
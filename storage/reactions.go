@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Reaction is one emoji under a message with the number of peers using it.
+type Reaction struct {
+	Emoji string
+	Count int
+	Mine  bool // we are one of them
+}
+
+// ToggleReaction adds our emoji to message msgID, or takes it back if we
+// already reacted with it. It reports whether the reaction is now present.
+func (s *Store) ToggleReaction(ctx context.Context, msgID, emoji string, at int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM reactions WHERE msg_id = ? AND sender = '' AND emoji = ?`, msgID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, nil
+	}
+	return true, s.SetReaction(ctx, msgID, "", emoji, false, at)
+}
+
+// SetReaction records or removes one sender's reaction. sender is the
+// reacting peer's ID, empty for our own reactions. Each sender counts once
+// per emoji; reactions to unknown messages are dropped.
+func (s *Store) SetReaction(ctx context.Context, msgID, sender, emoji string, remove bool, at int64) error {
+	sender = s.peerIndex(sender)
+	if s.MetadataEncrypted() {
+		at = 0 // reactions are ordered by insertion then
+	}
+	if remove {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM reactions WHERE msg_id = ? AND sender = ? AND emoji = ?`, msgID, sender, emoji); err != nil {
+			return fmt.Errorf("failed to remove reaction: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+		INSERT OR IGNORE INTO reactions (msg_id, sender, emoji, created_at)
+		SELECT ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM messages WHERE msg_id = ? AND deleted = 0)`
+	res, err := s.db.ExecContext(ctx, query, msgID, sender, emoji, at, msgID)
+	if err != nil {
+		return fmt.Errorf("failed to save reaction: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Debug("reaction not stored", "msg", msgID, "sender", sender)
+	}
+	return nil
+}
+
+// loadReactions fills in the reactions of messages.
+func (s *Store) loadReactions(ctx context.Context, messages []Message) error {
+	index := make(map[string][]int)
+	var args []any
+	for i, m := range messages {
+		if m.MsgID == "" {
+			continue
+		}
+		if _, ok := index[m.MsgID]; !ok {
+			args = append(args, m.MsgID)
+		}
+		index[m.MsgID] = append(index[m.MsgID], i)
+	}
+	if len(index) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT msg_id, emoji, COUNT(*), MAX(sender = '')
+		FROM reactions
+		WHERE msg_id IN (?` + strings.Repeat(", ?", len(index)-1) + `)
+		GROUP BY msg_id, emoji
+		ORDER BY MIN(created_at), MIN(rowid), emoji`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msgID string
+		var r Reaction
+		if err := rows.Scan(&msgID, &r.Emoji, &r.Count, &r.Mine); err != nil {
+			return err
+		}
+		for _, i := range index[msgID] {
+			messages[i].Reactions = append(messages[i].Reactions, r)
+		}
+	}
+	return rows.Err()
+}
@@ -6,7 +6,7 @@ import (
 )
 
 // createSchema creates the necessary database tables.
-func createSchema(ctx context.Context) error {
+func (s *Store) createSchema(ctx context.Context) error {
 	// Create messages table
 	query := `
 	CREATE TABLE IF NOT EXISTS messages (
@@ -19,10 +19,131 @@ func createSchema(ctx context.Context) error {
 	CREATE INDEX IF NOT EXISTS idx_messages_peer_id ON messages(peer_id);
 	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
 	`
-	if _, err := DB.ExecContext(ctx, query); err != nil {
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
 		return fmt.Errorf("failed to create messages table: %w", err)
 	}
 
+	// Create files table (transferred files, referenced from messages)
+	filesQuery := `
+	CREATE TABLE IF NOT EXISTS files (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		stored BOOLEAN NOT NULL
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, filesQuery); err != nil {
+		return fmt.Errorf("failed to create files table: %w", err)
+	}
+	if err := s.addColumn(ctx, "messages", "file_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Message IDs shared with peers, so edits, deletes and replies can refer
+	// to them
+	if err := s.addColumn(ctx, "messages", "msg_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "messages", "edited_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "messages", "deleted", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "messages", "reply_to", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	editsQuery := `
+	CREATE INDEX IF NOT EXISTS idx_messages_msg_id ON messages(msg_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(reply_to);
+	CREATE TABLE IF NOT EXISTS message_edits (
+		message_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		replaced_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id);
+	`
+	if _, err := s.db.ExecContext(ctx, editsQuery); err != nil {
+		return fmt.Errorf("failed to create message_edits table: %w", err)
+	}
+
+	// Create reactions table (one row per sender and emoji)
+	reactionsQuery := `
+	CREATE TABLE IF NOT EXISTS reactions (
+		msg_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		emoji TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (msg_id, sender, emoji)
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, reactionsQuery); err != nil {
+		return fmt.Errorf("failed to create reactions table: %w", err)
+	}
+
+	// Create contacts table (nicknames, presence and status of known peers)
+	contactsQuery := `
+	CREATE TABLE IF NOT EXISTS contacts (
+		peer_id TEXT PRIMARY KEY,
+		nickname TEXT NOT NULL DEFAULT '',
+		presence TEXT NOT NULL DEFAULT '',
+		status_text TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, contactsQuery); err != nil {
+		return fmt.Errorf("failed to create contacts table: %w", err)
+	}
+
+	// Create read_markers table (the last message read per conversation).
+	// History from before it existed counts as read.
+	var hasMarkers int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'read_markers'`).Scan(&hasMarkers); err != nil {
+		return fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	markersQuery := `
+	CREATE TABLE IF NOT EXISTS read_markers (
+		peer_id TEXT PRIMARY KEY,
+		last_read INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, markersQuery); err != nil {
+		return fmt.Errorf("failed to create read_markers table: %w", err)
+	}
+	if hasMarkers == 0 {
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO read_markers (peer_id, last_read) SELECT peer_id, MAX(id) FROM messages GROUP BY peer_id`); err != nil {
+			return fmt.Errorf("failed to mark history read: %w", err)
+		}
+	}
+
+	// Create muted table (conversations that never notify)
+	mutedQuery := `
+	CREATE TABLE IF NOT EXISTS muted (
+		peer_id TEXT PRIMARY KEY
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, mutedQuery); err != nil {
+		return fmt.Errorf("failed to create muted table: %w", err)
+	}
+
+	// Encrypted metadata (see metadata.go): the peers behind peer indexes
+	// and sealed timestamps
+	peersQuery := `
+	CREATE TABLE IF NOT EXISTS peers (
+		id TEXT PRIMARY KEY,
+		peer TEXT NOT NULL
+	);
+	`
+	if _, err := s.db.ExecContext(ctx, peersQuery); err != nil {
+		return fmt.Errorf("failed to create peers table: %w", err)
+	}
+	if err := s.addColumn(ctx, "messages", "sealed_at", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "message_edits", "sealed_at", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
 	// Create metadata table (for encryption salt)
 	metaQuery := `
 	CREATE TABLE IF NOT EXISTS metadata (
@@ -30,9 +151,35 @@ func createSchema(ctx context.Context) error {
 		value BLOB
 	);
 	`
-	if _, err := DB.ExecContext(ctx, metaQuery); err != nil {
+	if _, err := s.db.ExecContext(ctx, metaQuery); err != nil {
 		return fmt.Errorf("failed to create metadata table: %w", err)
 	}
 
 	return nil
 }
+
+// addColumn adds a column to a table created by an older version.
+func (s *Store) addColumn(ctx context.Context, table, column, decl string) error {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
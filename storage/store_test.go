@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTest opens a store in a fresh temporary directory.
+func openTest(t *testing.T, dir, password string) *Store {
+	t.Helper()
+	s, err := Open(dir, password)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoresAreIndependent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	a := openTest(t, t.TempDir(), "alice")
+	b := openTest(t, t.TempDir(), "bob")
+
+	if err := a.SaveMessage(ctx, "peerA", "m1", "", "hello", 1, true); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	msgs, err := a.GetMessages(ctx, "peerA", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "hello" || msgs[0].MsgID != "m1" {
+		t.Errorf("GetMessages = %+v", msgs)
+	}
+	if msgs, _ := b.GetMessages(ctx, "peerA", 10); len(msgs) != 0 {
+		t.Errorf("second store sees %d messages of the first", len(msgs))
+	}
+}
+
+func TestWrongPasswordCannotDecrypt(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTest(t, dir, "secret")
+	if err := s.SaveMessage(ctx, "peerA", "m1", "", "hello", 1, false); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	s.Close()
+
+	other := openTest(t, dir, "wrong")
+	msgs, err := other.GetMessages(ctx, "peerA", 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Content, "[Decryption Failed") {
+		t.Errorf("GetMessages with the wrong password = %+v", msgs)
+	}
+}
+
+func TestClosedStoreFails(t *testing.T) {
+	t.Parallel()
+	s := openTest(t, t.TempDir(), "secret")
+	s.Close()
+	if _, err := s.Encrypt("hello"); err == nil {
+		t.Error("Encrypt succeeded after Close")
+	}
+	if err := s.SaveMessage(context.Background(), "peerA", "m1", "", "hello", 1, true); err == nil {
+		t.Error("SaveMessage succeeded after Close")
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	t.Parallel()
+	s := openTest(t, t.TempDir(), "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.GetConversations(ctx); err == nil {
+		t.Error("GetConversations ignored the canceled context")
+	}
+}
+
+func TestEncryptMetadataMigrates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTest(t, dir, "secret")
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(s.SaveMessage(ctx, "peerA", "m1", "", "hello", 1000, false))
+	must(s.SaveMessage(ctx, "peerB", "m2", "", "hi", 2000, true))
+	must(s.EditMessage(ctx, "m1", "peerA", false, "hello!", 1500))
+	must(s.SetReaction(ctx, "m2", "peerB", "👍", false, 2100))
+	must(s.SavePresence(ctx, "peerA", "away", "lunch", 1200))
+	must(s.SetMuted(ctx, "peerA", true))
+	must(s.MarkRead(ctx, "peerA"))
+
+	must(s.EncryptMetadata(ctx))
+	if !s.MetadataEncrypted() {
+		t.Fatal("metadata not encrypted after EncryptMetadata")
+	}
+
+	// Nothing in the file names a peer or dates a message any more
+	for _, q := range []string{
+		"SELECT COUNT(*) FROM messages WHERE peer_id IN ('peerA', 'peerB') OR timestamp != 0",
+		"SELECT COUNT(*) FROM contacts WHERE peer_id = 'peerA' OR updated_at != 0",
+		"SELECT COUNT(*) FROM muted WHERE peer_id = 'peerA'",
+		"SELECT COUNT(*) FROM read_markers WHERE peer_id = 'peerA'",
+		"SELECT COUNT(*) FROM reactions WHERE sender = 'peerB' OR created_at != 0",
+		"SELECT COUNT(*) FROM message_edits WHERE replaced_at != 0",
+	} {
+		var n int
+		must(s.db.QueryRowContext(ctx, q).Scan(&n))
+		if n != 0 {
+			t.Errorf("%s = %d, want 0", q, n)
+		}
+	}
+
+	// Reopening keeps the mode, and reads give back what was stored
+	s.Close()
+	s = openTest(t, dir, "secret")
+	if !s.MetadataEncrypted() {
+		t.Fatal("metadata mode lost on reopen")
+	}
+	must(s.SaveMessage(ctx, "peerA", "m3", "", "again", 3000, false))
+
+	msgs, err := s.GetMessages(ctx, "peerA", 10)
+	must(err)
+	if len(msgs) != 2 || msgs[0].Content != "hello!" || msgs[0].Timestamp != 1000 || msgs[0].PeerID != "peerA" || msgs[1].Timestamp != 3000 {
+		t.Errorf("GetMessages = %+v", msgs)
+	}
+	edits, err := s.GetEdits(ctx, msgs[0].ID)
+	must(err)
+	if len(edits) != 1 || edits[0].Content != "hello" || edits[0].ReplacedAt != 1500 {
+		t.Errorf("GetEdits = %+v", edits)
+	}
+	convs, err := s.GetConversations(ctx)
+	must(err)
+	if len(convs) != 2 || convs[0].PeerID != "peerA" || convs[0].LastActivity != 3000 || convs[0].Unread != 1 || convs[1].PeerID != "peerB" {
+		t.Errorf("GetConversations = %+v", convs)
+	}
+	contacts, err := s.GetContacts(ctx)
+	must(err)
+	if len(contacts) != 1 || contacts[0].PeerID != "peerA" || contacts[0].StatusText != "lunch" {
+		t.Errorf("GetContacts = %+v", contacts)
+	}
+	muted, err := s.GetMuted(ctx)
+	must(err)
+	if len(muted) != 1 || muted[0] != "peerA" {
+		t.Errorf("GetMuted = %v", muted)
+	}
+	if err := s.EditMessage(ctx, "m3", "peerB", false, "stolen", 3100); err != ErrNotFound {
+		t.Errorf("edit by another peer = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEncryptMetadataWrongPassword(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTest(t, dir, "secret")
+	if err := s.SaveMessage(ctx, "peerA", "m1", "", "hello", 1, false); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	s.Close()
+
+	other := openTest(t, dir, "wrong")
+	if err := other.EncryptMetadata(ctx); err != ErrWrongKey {
+		t.Fatalf("EncryptMetadata = %v, want ErrWrongKey", err)
+	}
+	if other.MetadataEncrypted() {
+		t.Error("metadata mode on after a failed migration")
+	}
+}
+
+func TestSetNickname(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s := openTest(t, t.TempDir(), "secret")
+	if err := s.SavePresence(ctx, "peerA", "online", "here", 1); err != nil {
+		t.Fatalf("SavePresence: %v", err)
+	}
+	if err := s.SetNickname(ctx, "peerA", "alice"); err != nil {
+		t.Fatalf("SetNickname: %v", err)
+	}
+	if err := s.SetNickname(ctx, "peerB", "bob"); err != nil {
+		t.Fatalf("SetNickname for a new contact: %v", err)
+	}
+	// Presence updates keep the nickname
+	if err := s.SavePresence(ctx, "peerA", "away", "", 2); err != nil {
+		t.Fatalf("SavePresence: %v", err)
+	}
+
+	var stored string
+	if err := s.db.QueryRowContext(ctx, "SELECT nickname FROM contacts WHERE peer_id = 'peerA'").Scan(&stored); err != nil || stored == "alice" {
+		t.Errorf("nickname stored as %q, %v; want it encrypted", stored, err)
+	}
+	contacts, err := s.GetContacts(ctx)
+	if err != nil {
+		t.Fatalf("GetContacts: %v", err)
+	}
+	nicks := make(map[string]string)
+	for _, c := range contacts {
+		nicks[c.PeerID] = c.Nickname
+	}
+	if nicks["peerA"] != "alice" || nicks["peerB"] != "bob" {
+		t.Errorf("nicknames = %v", nicks)
+	}
+}
+
+func TestExpirePartials(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s := openTest(t, t.TempDir(), "secret")
+	stale, fresh, kept := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	for _, id := range []string{stale, fresh, kept} {
+		p, err := s.OpenPartial(id)
+		if err != nil {
+			t.Fatalf("OpenPartial: %v", err)
+		}
+		p.Write([]byte("data"))
+		p.Close()
+	}
+	if err := s.SaveFileMessage(ctx, "peerA", File{ID: kept, Name: "kept", Size: 4, Stored: true}, 1, false); err != nil {
+		t.Fatalf("SaveFileMessage: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, id := range []string{stale, kept} {
+		if err := os.Chtimes(filepath.Join(s.filesDir, id), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.ExpirePartials(ctx, 24*time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("ExpirePartials = %d, %v, want 1", n, err)
+	}
+	for id, want := range map[string]bool{stale: false, fresh: true, kept: true} {
+		if _, err := os.Stat(filepath.Join(s.filesDir, id)); (err == nil) != want {
+			t.Errorf("file %s exists = %t, want %t", id[:1], err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Conversation summarises the stored messages of one conversation for the
+// sidebar.
+type Conversation struct {
+	PeerID       string
+	LastActivity int64 // timestamp of the newest message
+	Unread       int   // received messages after the read marker
+}
+
+// GetConversations returns every conversation with messages, most recently
+// active first.
+func (s *Store) GetConversations(ctx context.Context) ([]Conversation, error) {
+	// The newest message is the last inserted, whose timestamp may be sealed
+	query := `
+		SELECT m.peer_id, m.timestamp, m.sealed_at, c.unread
+		FROM (
+			SELECT m.peer_id, MAX(m.id) AS last,
+				SUM(CASE WHEN m.is_sent = 0 AND m.deleted = 0 AND m.id > COALESCE(r.last_read, 0) THEN 1 ELSE 0 END) AS unread
+			FROM messages m
+			LEFT JOIN read_markers r ON r.peer_id = m.peer_id
+			GROUP BY m.peer_id
+		) c
+		JOIN messages m ON m.id = c.last
+		ORDER BY m.timestamp DESC, m.id DESC`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var convs []Conversation
+	for rows.Next() {
+		var c Conversation
+		var sealedAt string
+		if err := rows.Scan(&c.PeerID, &c.LastActivity, &sealedAt, &c.Unread); err != nil {
+			return nil, err
+		}
+		c.LastActivity = s.openTime(c.LastActivity, sealedAt)
+		convs = append(convs, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range convs {
+		convs[i].PeerID = s.peerID(ctx, convs[i].PeerID)
+	}
+	return convs, nil
+}
+
+// MarkRead moves the read marker of peerID's conversation past its newest
+// message.
+func (s *Store) MarkRead(ctx context.Context, peerID string) error {
+	query := `
+		INSERT INTO read_markers (peer_id, last_read)
+		SELECT ?, COALESCE(MAX(id), 0) FROM messages WHERE peer_id = ?
+		ON CONFLICT(peer_id) DO UPDATE SET last_read = excluded.last_read`
+	peer := s.peerIndex(peerID)
+	if _, err := s.db.ExecContext(ctx, query, peer, peer); err != nil {
+		return fmt.Errorf("failed to mark read: %w", err)
+	}
+	return nil
+}
+
+// SetMuted mutes or unmutes notifications for peerID's conversation.
+func (s *Store) SetMuted(ctx context.Context, peerID string, muted bool) error {
+	query := `DELETE FROM muted WHERE peer_id = ?`
+	if muted {
+		query = `INSERT OR IGNORE INTO muted (peer_id) VALUES (?)`
+	}
+	peer, err := s.addPeer(ctx, s.db, peerID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, query, peer); err != nil {
+		return fmt.Errorf("failed to save mute: %w", err)
+	}
+	return nil
+}
+
+// GetMuted returns the muted conversations.
+func (s *Store) GetMuted(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT peer_id FROM muted`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query muted conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var peers []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range peers {
+		peers[i] = s.peerID(ctx, peers[i])
+	}
+	return peers, nil
+}
@@ -0,0 +1,160 @@
+package theme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the theme used when config.json names none.
+const Default = "green"
+
+// Theme is a color palette shared by the TUI and the GUI. Colors are
+// "#rrggbb" or an ANSI color number from "0" to "255"; the numbers follow
+// the terminal's own palette in the TUI.
+type Theme struct {
+	Name string `json:"name"`
+	// Light marks palettes meant for a light background, so Markdown and
+	// the GUI's own widgets pick their light variants.
+	Light      bool   `json:"light"`
+	Background string `json:"background"`
+	Foreground string `json:"foreground"` // text, our messages, the active item
+	Accent     string `json:"accent"`     // peers' messages, unread counts
+	Muted      string `json:"muted"`      // borders, timestamps, offline peers
+	Error      string `json:"error"`
+}
+
+var builtin = []Theme{
+	{Name: "green", Background: "0", Foreground: "2", Accent: "3", Muted: "8", Error: "1"},
+	{Name: "amber", Background: "0", Foreground: "3", Accent: "11", Muted: "8", Error: "1"},
+	{Name: "solarized", Background: "#002b36", Foreground: "#2aa198", Accent: "#b58900", Muted: "#586e75", Error: "#dc322f"},
+	{Name: "high-contrast", Background: "#000000", Foreground: "#ffffff", Accent: "#ffff00", Muted: "#c0c0c0", Error: "#ff5f5f"},
+	{Name: "light", Light: true, Background: "#ffffff", Foreground: "#005f00", Accent: "#875f00", Muted: "#6c6c6c", Error: "#af0000"},
+}
+
+// Builtin returns the themes that ship with shellchat, the default first.
+func Builtin() []Theme {
+	return append([]Theme(nil), builtin...)
+}
+
+// Load returns the built-in themes followed by the user themes in the
+// themes directory under dir, sorted by name. A user theme may leave out
+// colors, which then come from the default, and replaces a built-in theme
+// of the same name. Files that cannot be read are reported in the error;
+// the other themes are still returned.
+func Load(dir string) ([]Theme, error) {
+	themes := Builtin()
+	paths, err := filepath.Glob(filepath.Join(dir, "themes", "*.json"))
+	if err != nil {
+		return themes, err
+	}
+	sort.Strings(paths)
+	var errs []error
+	for _, path := range paths {
+		t, err := loadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if i := index(themes, t.Name); i >= 0 {
+			themes[i] = t
+		} else {
+			themes = append(themes, t)
+		}
+	}
+	return themes, errors.Join(errs...)
+}
+
+func loadFile(path string) (Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Theme{}, err
+	}
+	t := builtin[0]
+	t.Name = ""
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Theme{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	for _, c := range []string{t.Background, t.Foreground, t.Accent, t.Muted, t.Error} {
+		if _, ok := RGB(c); !ok {
+			return Theme{}, fmt.Errorf("%s: invalid color %q", path, c)
+		}
+	}
+	return t, nil
+}
+
+// Find returns the theme called name.
+func Find(themes []Theme, name string) (Theme, bool) {
+	if i := index(themes, name); i >= 0 {
+		return themes[i], true
+	}
+	return Theme{}, false
+}
+
+func index(themes []Theme, name string) int {
+	for i, t := range themes {
+		if strings.EqualFold(t.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Names lists the names of themes in order.
+func Names(themes []Theme) []string {
+	names := make([]string, len(themes))
+	for i, t := range themes {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// ansi16 is xterm's default palette for the first 16 ANSI colors.
+var ansi16 = [16]uint32{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00, 0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00, 0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+// RGB converts a theme color for places without a terminal palette. ANSI
+// numbers map to xterm's defaults.
+func RGB(c string) (color.NRGBA, bool) {
+	if hex, ok := strings.CutPrefix(c, "#"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return color.NRGBA{}, false
+		}
+		return rgb(uint32(v)), true
+	}
+	n, err := strconv.Atoi(c)
+	switch {
+	case err != nil || n < 0 || n > 255:
+		return color.NRGBA{}, false
+	case n < 16:
+		return rgb(ansi16[n]), true
+	case n < 232:
+		// 6x6x6 color cube
+		level := func(i int) uint32 {
+			if i == 0 {
+				return 0
+			}
+			return uint32(55 + 40*i)
+		}
+		n -= 16
+		return rgb(level(n/36)<<16 | level(n/6%6)<<8 | level(n%6)), true
+	}
+	gray := uint32(8 + 10*(n-232))
+	return rgb(gray<<16 | gray<<8 | gray), true
+}
+
+func rgb(v uint32) color.NRGBA {
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
@@ -0,0 +1,69 @@
+package theme
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadUserThemes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "themes"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"dusk.json":   `{"foreground": "#ff8700"}`,
+		"light.json":  `{"name": "light", "light": true, "background": "#eeeeee"}`,
+		"broken.json": `{"accent": "orange"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, "themes", name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	themes, err := Load(dir)
+	if err == nil {
+		t.Error("Load did not report the invalid color")
+	}
+	dusk, ok := Find(themes, "dusk")
+	if !ok {
+		t.Fatalf("dusk not loaded, got %v", Names(themes))
+	}
+	// Colors left out come from the default theme
+	if dusk.Foreground != "#ff8700" || dusk.Accent != builtin[0].Accent {
+		t.Errorf("dusk = %+v", dusk)
+	}
+	if light, _ := Find(themes, "Light"); light.Background != "#eeeeee" {
+		t.Errorf("user theme did not replace the built-in one: %+v", light)
+	}
+	if _, ok := Find(themes, "broken"); ok {
+		t.Error("theme with an invalid color was loaded")
+	}
+	if len(themes) != len(builtin)+1 {
+		t.Errorf("Load returned %v", Names(themes))
+	}
+}
+
+func TestRGB(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.NRGBA
+		ok   bool
+	}{
+		{"#2aa198", color.NRGBA{0x2a, 0xa1, 0x98, 0xff}, true},
+		{"2", color.NRGBA{0x00, 0xcd, 0x00, 0xff}, true},
+		{"196", color.NRGBA{0xff, 0x00, 0x00, 0xff}, true},
+		{"244", color.NRGBA{0x80, 0x80, 0x80, 0xff}, true},
+		{"256", color.NRGBA{}, false},
+		{"#fff", color.NRGBA{}, false},
+		{"green", color.NRGBA{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := RGB(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("RGB(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package ui
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"shellchat/commands"
+	"shellchat/config"
+	"shellchat/p2p"
+	"shellchat/session"
+	"shellchat/storage"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"golang.org/x/term"
+)
+
+// Accessible mode (chat --accessible) prints the chat as a plain transcript
+// for screen readers and braille displays: one line per message or event,
+// without colors, borders or cursor movement. Input is read a line at a
+// time, so the terminal's own echo and line editing keep working.
+
+// transcriptBacklog is how many messages are repeated on opening a
+// conversation.
+const transcriptBacklog = 10
+
+// handleLen is how much of a Peer ID names a peer without a nickname.
+const handleLen = 6
+
+// transcript is the accessible front-end.
+type transcript struct {
+	host    *p2p.ChatHost
+	session *session.Session
+	in      *bufio.Scanner
+	out     io.Writer
+
+	mu       sync.Mutex // guards out, active and presence
+	active   string
+	presence map[string]string // peer -> last announced status
+}
+
+// lineHandler runs one slash command in accessible mode.
+type lineHandler func(t *transcript, arg string)
+
+var lineCommands = commands.NewRegistry[lineHandler]()
+
+func init() {
+	lineCommands.Handle("myid", func(t *transcript, _ string) {
+		t.say("My addresses:")
+		t.sayLines(p2p.FormatAddrGroups(t.host.AddrGroups()))
+	})
+	lineCommands.Handle("connect", (*transcript).connect)
+	lineCommands.Handle("peers", func(t *transcript, _ string) {
+		peers := t.host.ChatPeers()
+		if len(peers) == 0 {
+			t.say("No peers connected.")
+			return
+		}
+		t.say("%d connected:", len(peers))
+		for _, p := range peers {
+			t.say("%s, %s", t.name(p), t.status(p))
+		}
+	})
+	lineCommands.Handle("nick", func(t *transcript, arg string) {
+		t.say("%s", setNickname(t.session, arg))
+	})
+	lineCommands.Handle("save", func(t *transcript, arg string) {
+		msgs, err := t.session.Messages(t.conversation())
+		if err != nil {
+			t.say("Failed to load the conversation: %v", err)
+			return
+		}
+		t.say("%s", saveFile(t.session, msgs, arg))
+	})
+	lineCommands.Handle("accept", func(t *transcript, arg string) {
+		t.say("%s", answerOffer(t.session, arg, true))
+	})
+	lineCommands.Handle("decline", func(t *transcript, arg string) {
+		t.say("%s", answerOffer(t.session, arg, false))
+	})
+	lineCommands.Handle("mute", func(t *transcript, _ string) {
+		muted, err := t.session.ToggleMute(t.conversation())
+		switch {
+		case err != nil:
+			t.say("Failed to change mute: %v", err)
+		case muted:
+			t.say("Notifications muted for this conversation.")
+		default:
+			t.say("Notifications unmuted for this conversation.")
+		}
+	})
+	lineCommands.Handle("status", func(t *transcript, arg string) {
+		state, text := t.session.Status()
+		if arg != "" {
+			state, text = t.session.SetStatus(arg)
+		}
+		if text != "" {
+			state += ", " + text
+		}
+		t.say("Your status: %s.", state)
+	})
+	lineCommands.Handle("lock", func(t *transcript, _ string) {
+		if err := t.session.Lock(); err != nil {
+			log.Error("failed to lock", "err", err)
+			t.say("Failed to lock: %v", err)
+			return
+		}
+		t.say("Locked. Messages that arrive are kept until you unlock.")
+		// At the end of input the main loop finds it too and stops
+		if err := t.unlock(); err != nil {
+			return
+		}
+		t.announceUnread()
+		t.open(t.conversation())
+	})
+	lineCommands.Handle("exit", func(t *transcript, _ string) {
+		t.open(session.GlobalRoom)
+	})
+	// RunAccessible stops reading input on /quit
+	lineCommands.Handle("quit", func(*transcript, string) {})
+	lineCommands.Handle("help", func(t *transcript, _ string) {
+		t.say("Commands:")
+		t.sayLines(lineCommands.Help())
+		t.say("Anything else is sent to the open conversation.")
+	})
+}
+
+// RunAccessible runs the accessible front-end on the standard input and
+// output until /quit or the end of input.
+func RunAccessible(host *p2p.ChatHost, cfg *config.Config) error {
+	var sessionHost session.Host
+	if host != nil {
+		sessionHost = host
+	}
+	t := &transcript{
+		host:     host,
+		session:  session.New(sessionHost, cfg),
+		out:      os.Stdout,
+		active:   session.GlobalRoom,
+		presence: make(map[string]string),
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 0, 4096), maxMessageLen*4)
+	t.in = in
+	if err := t.unlock(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	if err := t.session.LoadContacts(); err != nil {
+		log.Error("failed to load contacts", "err", err)
+	}
+	t.announceUnread()
+
+	if host != nil {
+		for _, p := range host.ChatPeers() {
+			t.session.HandlePeerEvent(p2p.PeerEvent{Kind: p2p.EventPeerConnected, Peer: p})
+		}
+		go t.receive()
+		go t.watchPeers()
+	}
+	t.open(session.GlobalRoom)
+	t.say("Type a message and press Enter to send it. /help lists the commands.")
+
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		}
+		if !commands.IsCommand(line) {
+			t.send(commands.Unescape(line))
+			continue
+		}
+		cmd, handle, arg, err := lineCommands.Lookup(line)
+		if err != nil {
+			t.say("%v", err)
+			continue
+		}
+		if cmd.Name == "quit" {
+			return nil
+		}
+		handle(t, arg)
+	}
+	return in.Err()
+}
+
+// unlock asks for the master password until the history opens.
+func (t *transcript) unlock() error {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	for {
+		password, err := t.readPassword()
+		if err != nil {
+			return err
+		}
+		if err := t.session.Unlock(dir, password); err != nil {
+			log.Warn("unlock failed", "err", err)
+			t.say("Unlock failed: %v. Try again.", err)
+			continue
+		}
+		t.say("Unlocked.")
+		return nil
+	}
+}
+
+// readPassword reads the password without echo from a terminal, or as a
+// plain line from a pipe.
+func (t *transcript) readPassword() (string, error) {
+	fmt.Fprint(t.out, "Master password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(t.out)
+		return string(password), err
+	}
+	if !t.in.Scan() {
+		if err := t.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return t.in.Text(), nil
+}
+
+// announceUnread loads the unread counts and reads out the conversations
+// that have any.
+func (t *transcript) announceUnread() {
+	if err := t.session.LoadConversations(); err != nil {
+		log.Error("failed to load conversations", "err", err)
+	}
+	for _, p := range t.session.Conversations() {
+		if n := t.session.Unread(p); n > 0 {
+			t.say("%d unread from %s.", n, t.name(p))
+		}
+	}
+}
+
+// say prints one line. Peers choose much of the text, so control
+// characters are removed and line breaks become spaces.
+func (t *transcript) say(format string, args ...any) {
+	line := strings.Join(strings.Fields(sanitize(fmt.Sprintf(format, args...))), " ")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintln(t.out, line)
+}
+
+// sayLines prints text that is already split into lines, one by one.
+func (t *transcript) sayLines(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		t.say("%s", line)
+	}
+}
+
+// conversation returns the open conversation.
+func (t *transcript) conversation() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// name is how a peer is read out: the nickname, or "peer" and its handle.
+func (t *transcript) name(p string) string {
+	if p == session.GlobalRoom {
+		return "the global room"
+	}
+	if nick := t.session.Contact(p).Nickname; nick != "" {
+		return nick
+	}
+	return "peer " + t.handle(p)
+}
+
+// handle is what the user types to name a peer in /connect: the nickname,
+// or the end of the Peer ID, which unlike its start differs between peers.
+func (t *transcript) handle(p string) string {
+	if nick := t.session.Contact(p).Nickname; nick != "" {
+		return nick
+	}
+	return p[max(0, len(p)-handleLen):]
+}
+
+// status describes a peer's presence and status text.
+func (t *transcript) status(p string) string {
+	c := t.session.Contact(p)
+	state := c.Presence
+	if state == "" {
+		state = p2p.PresenceOnline
+	}
+	if !t.session.Online(p) {
+		state = p2p.PresenceOffline
+	}
+	if c.StatusText != "" {
+		return state + ", " + c.StatusText
+	}
+	return state
+}
+
+// messageLine formats a stored message as sender, time and text.
+func (t *transcript) messageLine(msg storage.Message) string {
+	sender := "You"
+	if !msg.IsSent {
+		sender = t.name(msg.PeerID)
+	}
+	var text string
+	switch {
+	case msg.Deleted:
+		text = "message deleted"
+	case msg.File != nil:
+		text = fmt.Sprintf("sent the file %s, %s", msg.File.Name, p2p.FormatSize(msg.File.Size))
+	default:
+		text = msg.Content
+		if msg.EditedAt != 0 {
+			text += " (edited)"
+		}
+	}
+	if msg.Quote != nil && msg.Quote.Content != "" {
+		text += fmt.Sprintf(" (in reply to: %s)", truncate(msg.Quote.Content, 40))
+	}
+	return fmt.Sprintf("%s, %s: %s", sender, time.Unix(msg.Timestamp, 0).Format("15:04"), text)
+}
+
+// truncate shortens s to n runes.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
+// open switches to peer's conversation and repeats its latest messages.
+func (t *transcript) open(peer string) {
+	t.mu.Lock()
+	t.active = peer
+	t.mu.Unlock()
+	t.session.Open(peer)
+
+	msgs, err := t.session.Messages(peer)
+	if err != nil {
+		log.Error("failed to load history", "peer", peer, "err", err)
+		t.say("Failed to load the conversation: %v", err)
+		return
+	}
+	count := fmt.Sprintf("%d messages", len(msgs))
+	if len(msgs) == 1 {
+		count = "1 message"
+	}
+	t.say("Now in the conversation with %s, %s.", t.name(peer), count)
+	for _, msg := range msgs[max(0, len(msgs)-transcriptBacklog):] {
+		t.say("%s", t.messageLine(msg))
+	}
+}
+
+// send sends a line to the open conversation. The terminal has already
+// echoed it, so only failures are printed.
+func (t *transcript) send(text string) {
+	if len(text) > maxMessageLen {
+		t.say("Message too long, the limit is %d characters.", maxMessageLen)
+		return
+	}
+	if err := t.session.Send(t.conversation(), "", text); err != nil {
+		log.Error("failed to save sent message", "err", err)
+		t.say("Sending failed: %v", err)
+	}
+}
+
+// receive announces incoming envelopes until the host stops.
+func (t *transcript) receive() {
+	for in := range t.host.MsgChan {
+		ev, ok := t.session.Receive(in)
+		if !ok {
+			continue
+		}
+		active := t.conversation()
+		switch ev.Kind {
+		case session.EventMessage:
+			if session.Shows(active, ev.Peer) {
+				t.say("%s, %s: %s", t.name(ev.Peer), time.Now().Format("15:04"), ev.Text)
+			} else {
+				t.say("New message from %s. /connect %s opens the conversation.", t.name(ev.Peer), t.handle(ev.Peer))
+			}
+		case session.EventChanged:
+			if session.Shows(active, ev.Peer) {
+				t.say("%s changed a message.", t.name(ev.Peer))
+			}
+		case session.EventPresence:
+			t.announceStatus(ev.Peer)
+		}
+	}
+}
+
+// announceStatus reads out a peer's status when it differs from the last
+// one announced; presence is resent on every connect.
+func (t *transcript) announceStatus(p string) {
+	status := t.status(p)
+	t.mu.Lock()
+	changed := t.presence[p] != status
+	t.presence[p] = status
+	t.mu.Unlock()
+	if changed {
+		t.say("%s is %s.", t.name(p), status)
+	}
+}
+
+// watchPeers announces connections and finished file transfers.
+func (t *transcript) watchPeers() {
+	sub, err := t.host.SubscribeEvents()
+	if err != nil {
+		log.Error("failed to subscribe to peer events", "err", err)
+		return
+	}
+	for e := range sub.Out() {
+		switch e := e.(type) {
+		case p2p.FileEvent:
+			switch {
+			case t.session.ReceiveFile(e):
+				t.say("%s sent the file %s, %s. /save %s keeps a copy.", t.name(e.Peer), e.Name, p2p.FormatSize(e.Size), e.Name)
+			case e.Offered:
+				t.say("%s offers the file %s, %s. /accept or /decline it.", t.name(e.Peer), e.Name, p2p.FormatSize(e.Size))
+			case e.Incoming && e.Err != nil && !errors.Is(e.Err, p2p.ErrOfferDeclined):
+				t.say("Receiving %s from %s failed: %v", e.Name, t.name(e.Peer), e.Err)
+			}
+		case p2p.PeerEvent:
+			was := t.session.Online(e.Peer)
+			t.session.HandlePeerEvent(e)
+			switch {
+			case e.Kind == p2p.EventPeerConnected && !was:
+				t.say("%s connected.", t.name(e.Peer))
+			case e.Kind == p2p.EventPeerDisconnected && was:
+				t.say("%s disconnected.", t.name(e.Peer))
+			}
+		}
+	}
+}
+
+// connect handles /connect. The conversation with a known peer, given by
+// Peer ID, nickname or handle, opens right away; other targets are dialed
+// as a multiaddr or looked up as a Peer ID.
+func (t *transcript) connect(target string) {
+	known, ok := t.session.FindPeer(target)
+	if !ok {
+		for _, p := range t.session.Conversations() {
+			if p != session.GlobalRoom && len(target) >= handleLen && strings.HasSuffix(p, target) {
+				known, ok = p, true
+				break
+			}
+		}
+	}
+	if ok {
+		t.open(known)
+		if t.session.Online(known) {
+			return
+		}
+		target = known
+	}
+
+	var pi peer.AddrInfo
+	if ma, err := multiaddr.NewMultiaddr(target); err == nil {
+		if info, err := peer.AddrInfoFromP2pAddr(ma); err == nil {
+			pi = *info
+		}
+	}
+	if pi.ID == "" {
+		pid, err := peer.Decode(target)
+		if err != nil {
+			t.say("Not a multiaddr, Peer ID or known nickname: %s", target)
+			return
+		}
+		pi.ID = pid
+	}
+
+	t.say("Connecting to %s.", t.name(pi.ID.String()))
+	go func() {
+		if len(pi.Addrs) == 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+			found, err := t.host.FindPeer(ctx, pi.ID)
+			cancel()
+			if err != nil {
+				t.say("Connect to %s failed: lookup failed: %v", t.name(pi.ID.String()), err)
+				return
+			}
+			pi = found
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		defer cancel()
+		if err := t.host.Connect(ctx, pi, "user"); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = errors.New("timed out")
+			}
+			t.say("Connect to %s failed: %v", t.name(pi.ID.String()), err)
+			return
+		}
+		peerID := pi.ID.String()
+		if t.session.AddConversation(peerID) || t.conversation() != peerID {
+			t.open(peerID)
+		}
+	}()
+}
@@ -0,0 +1,170 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/commands"
+	"shellchat/p2p"
+	"shellchat/session"
+	"shellchat/storage"
+	"shellchat/theme"
+
+	"github.com/atotto/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// commandHandler runs one slash command. The composer is already cleared.
+type commandHandler func(m *Model, arg string) tea.Cmd
+
+var tuiCommands = commands.NewRegistry[commandHandler]()
+
+// keyHelp follows the command list in /help.
+const keyHelp = `
+Enter sends, alt+enter starts a new line, ↑/↓ recall sent messages
+and ctrl+e composes in $EDITOR. Tab completes commands, peer IDs and
+nicknames; ctrl+p opens the command palette. alt+a jumps to the latest
+conversation with unread messages. When there is nothing to complete,
+tab moves between the contacts, the chat and the composer.
+
+Press alt+↑ to select a message, then r to reply, t to show its
+thread, 1-6 to react (👍 ❤️ 😂 😮 😢 🎉), e to edit or d to delete
+one of yours.
+`
+
+func init() {
+	tuiCommands.Handle("myid", func(m *Model, _ string) tea.Cmd {
+		m.viewport.SetContent(fmt.Sprintf("My Addresses:\n%s", p2p.FormatAddrGroups(m.host.AddrGroups())))
+		return nil
+	})
+	tuiCommands.Handle("copyid", func(m *Model, _ string) tea.Cmd {
+		fullAddr := strings.Join(m.host.FullAddrs(), "\n")
+		if err := clipboard.WriteAll(fullAddr); err != nil {
+			m.viewport.SetContent(fmt.Sprintf("Failed to copy: %v", err))
+		} else {
+			m.viewport.SetContent("Addresses copied to clipboard!")
+		}
+		return nil
+	})
+	tuiCommands.Handle("connect", func(m *Model, arg string) tea.Cmd {
+		cmd := m.startDial(arg)
+		m.updateView()
+		return cmd
+	})
+	tuiCommands.Handle("peers", func(m *Model, _ string) tea.Cmd {
+		peers := m.host.ChatPeers()
+		if len(peers) == 0 {
+			m.viewport.SetContent("No peers connected.")
+			return nil
+		}
+		m.viewport.SetContent("Connected Peers:\n" + strings.Join(peers, "\n"))
+		return nil
+	})
+	tuiCommands.Handle("send", func(m *Model, arg string) tea.Cmd {
+		return m.sendFileCmd(arg)
+	})
+	tuiCommands.Handle("save", func(m *Model, arg string) tea.Cmd {
+		m.viewport.SetContent(saveFile(m.session, m.messages, arg))
+		return nil
+	})
+	tuiCommands.Handle("nick", func(m *Model, arg string) tea.Cmd {
+		m.viewport.SetContent(setNickname(m.session, arg))
+		return nil
+	})
+	tuiCommands.Handle("accept", func(m *Model, arg string) tea.Cmd {
+		m.viewport.SetContent(answerOffer(m.session, arg, true))
+		return nil
+	})
+	tuiCommands.Handle("decline", func(m *Model, arg string) tea.Cmd {
+		m.viewport.SetContent(answerOffer(m.session, arg, false))
+		return nil
+	})
+	tuiCommands.Handle("thread", func(m *Model, _ string) tea.Cmd {
+		return m.openThread(nil)
+	})
+	tuiCommands.Handle("react", func(m *Model, arg string) tea.Cmd {
+		return m.toggleReaction(m.lastReactable(), arg)
+	})
+	tuiCommands.Handle("raw", func(m *Model, _ string) tea.Cmd {
+		m.rawText = !m.rawText
+		m.updateView()
+		return nil
+	})
+	tuiCommands.Handle("mute", func(m *Model, _ string) tea.Cmd {
+		m.viewport.SetContent(m.toggleMute())
+		return nil
+	})
+	tuiCommands.Handle("status", func(m *Model, arg string) tea.Cmd {
+		m.viewport.SetContent(m.setStatus(arg))
+		return nil
+	})
+	tuiCommands.Handle("theme", func(m *Model, arg string) tea.Cmd {
+		if arg == "" {
+			m.viewport.SetContent(m.themeList())
+			return nil
+		}
+		t, ok := theme.Find(m.themes, arg)
+		if !ok {
+			m.viewport.SetContent(fmt.Sprintf("Unknown theme %q.\n", arg) + m.themeList())
+			return nil
+		}
+		m.setTheme(t)
+		m.updateView()
+		return nil
+	})
+	tuiCommands.Handle("logs", func(m *Model, _ string) tea.Cmd {
+		m.showLogs = !m.showLogs
+		m.logSeq = 0
+		m.updateView()
+		if m.showLogs {
+			return logTickCmd()
+		}
+		return nil
+	})
+	tuiCommands.Handle("clear", func(m *Model, _ string) tea.Cmd {
+		m.messages = []storage.Message{}
+		m.updateView()
+		return nil
+	})
+	tuiCommands.Handle("wipe", func(m *Model, _ string) tea.Cmd {
+		if !m.confirmWipe {
+			m.confirmWipe = true
+			m.viewport.SetContent(ErrorStyle.Render(wipePrompt))
+			return nil
+		}
+		m.confirmWipe = false
+		if err := m.session.Clear(); err != nil {
+			m.viewport.SetContent(fmt.Sprintf("Error: %v", err))
+			return nil
+		}
+		m.messages = []storage.Message{}
+		m.updateView()
+		return nil
+	})
+	tuiCommands.Handle("exit", func(m *Model, _ string) tea.Cmd {
+		m.openConversation(session.GlobalRoom)
+		m.updateView()
+		return nil
+	})
+	tuiCommands.Handle("lock", func(m *Model, _ string) tea.Cmd {
+		return m.lock()
+	})
+	tuiCommands.Handle("quit", func(*Model, string) tea.Cmd {
+		return tea.Quit
+	})
+	tuiCommands.Handle("help", func(m *Model, _ string) tea.Cmd {
+		m.viewport.SetContent("\nCOMMANDS\n--------\n" + tuiCommands.Help() + keyHelp +
+			fmt.Sprintf("\n%s lists every key binding.\n", m.keys.Help.Help().Key))
+		return nil
+	})
+}
+
+// runCommand runs a line starting with a slash.
+func (m *Model) runCommand(input string) tea.Cmd {
+	_, handle, arg, err := tuiCommands.Lookup(input)
+	if err != nil {
+		m.viewport.SetContent(err.Error())
+		return nil
+	}
+	return handle(m, arg)
+}
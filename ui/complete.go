@@ -0,0 +1,192 @@
+package ui
+
+import (
+	"sort"
+	"strings"
+
+	"shellchat/commands"
+	"shellchat/p2p"
+	"shellchat/session"
+	"shellchat/theme"
+)
+
+// completion is a Tab-completion in progress. Further presses of Tab cycle
+// through the candidates as long as the composer still shows the last one.
+type completion struct {
+	base       string // composer text before the word being completed
+	candidates []string
+	index      int
+	shown      string // composer text after the last Tab
+}
+
+// complete handles Tab (forward) and shift+Tab. It completes the last word
+// of the composer: a command name, the argument of a command, or a peer ID
+// or nickname in a message. It reports false when there is nothing to do.
+func (m *Model) complete(forward bool) bool {
+	value := m.messageIn.Value()
+	c := m.completion
+	if c == nil || c.shown != value {
+		c = m.newCompletion(value)
+		if c == nil {
+			return false
+		}
+		m.completion = c
+	} else if forward {
+		c.index = (c.index + 1) % len(c.candidates)
+	} else {
+		c.index = (c.index + len(c.candidates) - 1) % len(c.candidates)
+	}
+
+	c.shown = c.base + c.candidates[c.index]
+	if len(c.candidates) == 1 {
+		c.shown += " "
+	}
+	m.messageIn.SetValue(c.shown)
+	m.stopRecall()
+	return true
+}
+
+// completionHint lists the candidates while Tab cycles through several,
+// for the status bar, or returns "".
+func (m Model) completionHint() string {
+	c := m.completion
+	if c == nil || len(c.candidates) < 2 || c.shown != m.messageIn.Value() {
+		return ""
+	}
+	parts := make([]string, len(c.candidates))
+	for i, cand := range c.candidates {
+		parts[i] = cand
+		if i == c.index {
+			parts[i] = "[" + cand + "]"
+		}
+	}
+	return "TAB: " + strings.Join(parts, " ")
+}
+
+func (m *Model) newCompletion(value string) *completion {
+	cut := strings.LastIndexAny(value, " \n") + 1
+	base, word := value[:cut], value[cut:]
+
+	var candidates []string
+	switch {
+	case commands.IsCommand(value) && cut == 0:
+		for _, name := range tuiCommands.Complete(strings.TrimPrefix(word, "/")) {
+			candidates = append(candidates, "/"+name)
+		}
+	case commands.IsCommand(value):
+		name, _ := commands.Parse(value)
+		cmd, ok := commands.Find(name)
+		if !ok {
+			return nil
+		}
+		candidates = m.argCandidates(cmd.Arg, word, strings.Count(strings.TrimSpace(base), " "))
+	default:
+		candidates = m.wordCandidates(word)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return &completion{base: base, candidates: candidates, index: 0}
+}
+
+// argCandidates completes the argument of a command. pos is the index of
+// the word being completed among the arguments.
+func (m *Model) argCandidates(arg commands.Arg, word string, pos int) []string {
+	var options []string
+	switch arg {
+	case commands.ArgPeer:
+		if pos > 0 {
+			return nil
+		}
+		// Nicknames complete to the peer ID the command needs
+		for _, p := range m.knownPeers() {
+			if strings.HasPrefix(p, word) || hasPrefixFold(m.session.Contact(p).Nickname, word) {
+				options = append(options, p)
+			}
+		}
+		sort.Strings(options)
+		return options
+	case commands.ArgStatus:
+		if pos > 0 {
+			return nil
+		}
+		options = []string{p2p.PresenceOnline, p2p.PresenceAway, p2p.PresenceDND}
+	case commands.ArgEmoji:
+		options = quickReactions
+	case commands.ArgTheme:
+		options = theme.Names(m.themes)
+	case commands.ArgOffer:
+		for _, o := range m.session.Offers() {
+			options = append(options, o.Name)
+		}
+	case commands.ArgFile:
+		for _, msg := range m.messages {
+			if msg.File != nil && msg.File.Stored {
+				options = append(options, msg.File.Name)
+			}
+		}
+	}
+	return matchPrefix(options, word)
+}
+
+// wordCandidates completes a word in a message with the nicknames and IDs
+// of known peers. An @ in front of the word is kept.
+func (m *Model) wordCandidates(word string) []string {
+	at := strings.HasPrefix(word, "@")
+	word = strings.TrimPrefix(word, "@")
+	if word == "" {
+		return nil
+	}
+	var options []string
+	for _, p := range m.knownPeers() {
+		if nick := m.session.Contact(p).Nickname; nick != "" {
+			options = append(options, nick)
+		}
+		options = append(options, p)
+	}
+	matches := matchPrefix(options, word)
+	if at {
+		for i := range matches {
+			matches[i] = "@" + matches[i]
+		}
+	}
+	return matches
+}
+
+// knownPeers returns every peer in the sidebar or the contact list.
+func (m *Model) knownPeers() []string {
+	seen := make(map[string]bool)
+	var peers []string
+	for _, p := range m.session.Conversations() {
+		if p != session.GlobalRoom && !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+	for _, p := range m.session.Contacts() {
+		if !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// matchPrefix returns the distinct options starting with word, ignoring
+// case, in sorted order.
+func matchPrefix(options []string, word string) []string {
+	seen := make(map[string]bool)
+	var matches []string
+	for _, o := range options {
+		if hasPrefixFold(o, word) && !seen[o] {
+			seen[o] = true
+			matches = append(matches, o)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return s != "" && len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
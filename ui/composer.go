@@ -0,0 +1,218 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"shellchat/p2p"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	// maxComposerLines is how tall the composer grows before it scrolls.
+	maxComposerLines = 5
+	// maxMessageLen caps a single message, in characters.
+	maxMessageLen = p2p.MaxMessageLen
+	// maxHistory is how many sent lines ↑ can recall.
+	maxHistory = 100
+)
+
+type editorDoneMsg struct {
+	text string
+	err  error
+}
+
+// newComposer returns the message textarea. Enter is left to Update, which
+// sends; alt+enter inserts a newline and ctrl+e opens $EDITOR instead of
+// jumping to the end of the line.
+func newComposer() textarea.Model {
+	ta := textarea.New()
+	ta.Placeholder = messagePlaceholder
+	ta.CharLimit = maxMessageLen
+	ta.MaxHeight = 0
+	ta.ShowLineNumbers = false
+	ta.SetHeight(1)
+	ta.SetWidth(80)
+	ta.SetPromptFunc(2, func(line int) string {
+		if line == 0 {
+			return "> "
+		}
+		return "  "
+	})
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	ta.KeyMap.LineEnd = key.NewBinding(key.WithKeys("end"))
+	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	ta.FocusedStyle.Prompt = lipgloss.NewStyle().Foreground(ColorGreen)
+	ta.FocusedStyle.Text = lipgloss.NewStyle().Foreground(ColorGreen)
+	ta.Focus()
+	return ta
+}
+
+// resizeComposer fits the composer to its text, up to maxComposerLines, and
+// gives the rest of the height to the chat pane.
+func (m *Model) resizeComposer() {
+	width := m.messageIn.Width()
+	lines := 0
+	for _, line := range strings.Split(m.messageIn.Value(), "\n") {
+		lines += max(1, (ansi.StringWidth(line)+width-1)/max(width, 1))
+	}
+	lines = min(lines, maxComposerLines)
+	if lines == m.messageIn.Height() {
+		return
+	}
+	m.messageIn.SetHeight(lines)
+	if m.height == 0 {
+		return
+	}
+	atBottom := m.viewport.AtBottom()
+	m.viewport.Height = m.height - 4 - lines // Header/Footer buffer
+	if atBottom {
+		m.viewport.GotoBottom()
+	}
+}
+
+// composerExtra is how many lines the composer takes beyond its first.
+func (m Model) composerExtra() int {
+	return m.messageIn.Height() - 1
+}
+
+// remember adds a sent line to the history ↑ recalls.
+func (m *Model) remember(content string) {
+	if n := len(m.history); n == 0 || m.history[n-1] != content {
+		m.history = append(m.history, content)
+		if len(m.history) > maxHistory {
+			m.history = m.history[len(m.history)-maxHistory:]
+		}
+	}
+	m.historyPos = len(m.history)
+	m.draft = ""
+}
+
+// recallHistory handles ↑ and ↓ in the composer. They step through sent
+// lines when the composer is empty or already showing one and the cursor
+// is on its first or last row; otherwise they move the cursor as usual.
+func (m *Model) recallHistory(k tea.KeyMsg) bool {
+	browsing := m.historyPos < len(m.history)
+	if !browsing && m.messageIn.Value() != "" {
+		return false
+	}
+	info := m.messageIn.LineInfo()
+	switch k.Type {
+	case tea.KeyUp:
+		if m.historyPos == 0 || m.messageIn.Line() > 0 || info.RowOffset > 0 {
+			return false
+		}
+		if !browsing {
+			m.draft = m.messageIn.Value()
+		}
+		m.historyPos--
+		m.messageIn.SetValue(m.history[m.historyPos])
+	case tea.KeyDown:
+		if !browsing || m.messageIn.Line() < m.messageIn.LineCount()-1 || info.RowOffset < info.Height-1 {
+			return false
+		}
+		m.historyPos++
+		if m.historyPos == len(m.history) {
+			m.messageIn.SetValue(m.draft)
+		} else {
+			m.messageIn.SetValue(m.history[m.historyPos])
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// stopRecall makes an edited recalled line the new draft.
+func (m *Model) stopRecall() {
+	m.historyPos = len(m.history)
+	m.draft = ""
+}
+
+// openEditorCmd composes the message in $VISUAL or $EDITOR, starting from
+// text. The temporary file lives in a private directory that is wiped once
+// the editor exits, swap and backup files included.
+func openEditorCmd(text string) tea.Cmd {
+	dir, err := os.MkdirTemp("", "shellchat-compose-")
+	if err != nil {
+		return func() tea.Msg { return editorDoneMsg{err: err} }
+	}
+	path := filepath.Join(dir, "message.md")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		wipeDir(dir)
+		return func() tea.Msg { return editorDoneMsg{err: err} }
+	}
+
+	args := append(editorCommand(), path)
+	c := exec.Command(args[0], args[1:]...)
+	// The editor needs the terminal itself, not the program's locked writer
+	c.Stdout = os.Stdout
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		defer wipeDir(dir)
+		if err != nil {
+			return editorDoneMsg{err: fmt.Errorf("editor failed: %w", err)}
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return editorDoneMsg{err: err}
+		}
+		return editorDoneMsg{text: strings.TrimRight(string(b), "\r\n")}
+	})
+}
+
+// editorCommand splits $VISUAL or $EDITOR, which may carry flags such as
+// "code --wait".
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// wipeDir overwrites every file under dir with zeros before removing it, so
+// the draft does not linger in free blocks.
+func wipeDir(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		return wipeFile(path)
+	})
+	if err != nil {
+		log.Warn("failed to wipe composer file", "err", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warn("failed to remove composer directory", "dir", dir, "err", err)
+	}
+}
+
+func wipeFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, werr := f.Write(make([]byte, info.Size()))
+	serr := f.Sync()
+	return errors.Join(werr, serr, f.Close())
+}
@@ -0,0 +1,190 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shellchat/p2p"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	connectTimeout = 10 * time.Second
+	lookupTimeout  = 30 * time.Second
+)
+
+// dialState tracks one /connect attempt. Model holds it by pointer so the
+// cancel func and progress survive Bubble Tea copying the model around.
+type dialState struct {
+	target  string // what the user typed
+	peer    peer.ID
+	stage   string // "looking up", "dialing"
+	started time.Time
+	tried   []string
+	cancel  context.CancelFunc
+
+	done     bool
+	finished time.Time
+	outcome  string
+	err      error
+}
+
+func (d *dialState) finish(err error) {
+	d.cancel()
+	d.done = true
+	d.finished = time.Now()
+	d.err = err
+}
+
+type lookupResultMsg struct {
+	dial *dialState
+	pi   peer.AddrInfo
+	err  error
+}
+
+type connectResultMsg struct {
+	dial *dialState
+	via  string // remote address of the connection we ended up with
+	err  error
+}
+
+// startDial parses a /connect argument and returns the first step of the
+// attempt: a direct dial for a multiaddr, a DHT lookup for a bare Peer ID.
+func (m *Model) startDial(target string) tea.Cmd {
+	if m.dial != nil && !m.dial.done {
+		m.dial.cancel()
+	}
+
+	if ma, err := multiaddr.NewMultiaddr(target); err == nil {
+		if pi, err := peer.AddrInfoFromP2pAddr(ma); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+			d := &dialState{target: target, peer: pi.ID, stage: "dialing", started: time.Now(), cancel: cancel}
+			for _, addr := range pi.Addrs {
+				d.tried = append(d.tried, addr.String())
+			}
+			m.dial = d
+			return tea.Batch(m.spinner.Tick, connectCmd(ctx, m.host, d, *pi))
+		}
+	}
+
+	if pid, err := peer.Decode(target); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		d := &dialState{target: target, peer: pid, stage: "looking up", started: time.Now(), cancel: cancel}
+		m.dial = d
+		return tea.Batch(m.spinner.Tick, lookupCmd(ctx, m.host, d, pid))
+	}
+
+	now := time.Now()
+	m.dial = &dialState{target: target, started: now, finished: now, done: true, cancel: func() {},
+		err: errors.New("not a multiaddr or Peer ID")}
+	return nil
+}
+
+// cancelDial aborts the running attempt, if any. Its result message is
+// ignored when it arrives.
+func (m *Model) cancelDial() bool {
+	if m.dial == nil || m.dial.done {
+		return false
+	}
+	m.dial.finish(errors.New("cancelled"))
+	return true
+}
+
+func lookupCmd(ctx context.Context, h *p2p.ChatHost, d *dialState, pid peer.ID) tea.Cmd {
+	return func() tea.Msg {
+		pi, err := h.FindPeer(ctx, pid)
+		return lookupResultMsg{dial: d, pi: pi, err: err}
+	}
+}
+
+func connectCmd(ctx context.Context, h *p2p.ChatHost, d *dialState, pi peer.AddrInfo) tea.Cmd {
+	return func() tea.Msg {
+		if err := h.Connect(ctx, pi, "user"); err != nil {
+			return connectResultMsg{dial: d, err: err}
+		}
+		var via string
+		if conns := h.P2PHost.Network().ConnsToPeer(pi.ID); len(conns) > 0 {
+			via = conns[0].RemoteMultiaddr().String()
+		}
+		return connectResultMsg{dial: d, via: via}
+	}
+}
+
+// handleLookupResult moves a DHT lookup on to dialing the addresses found.
+func (m *Model) handleLookupResult(msg lookupResultMsg) tea.Cmd {
+	d := msg.dial
+	if d != m.dial || d.done {
+		return nil
+	}
+	if msg.err != nil {
+		d.finish(fmt.Errorf("lookup failed: %w", msg.err))
+		return nil
+	}
+
+	d.stage = "dialing"
+	for _, addr := range msg.pi.Addrs {
+		d.tried = append(d.tried, addr.String())
+	}
+	// The dial gets its own deadline; cancelling still stops both steps
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	prev := d.cancel
+	d.cancel = func() { cancel(); prev() }
+	return connectCmd(ctx, m.host, d, msg.pi)
+}
+
+// handleConnectResult finishes the attempt and only then opens the chat.
+func (m *Model) handleConnectResult(msg connectResultMsg) {
+	d := msg.dial
+	if d != m.dial || d.done {
+		return
+	}
+	d.finish(msg.err)
+	if msg.err != nil {
+		return
+	}
+
+	d.outcome = fmt.Sprintf("Connected to %s in %s", shortID(d.peer.String()), d.finished.Sub(d.started).Round(100*time.Millisecond))
+	if msg.via != "" {
+		d.outcome += " via " + msg.via
+	}
+	m.session.AddConversation(d.peer.String())
+	m.openConversation(d.peer.String())
+}
+
+// shortID abbreviates a Peer ID for display.
+func shortID(p string) string {
+	if len(p) <= 12 {
+		return p
+	}
+	return p[:12] + "..."
+}
+
+// renderDial describes the current attempt for the bottom of the chat pane.
+func (m Model) renderDial() string {
+	d := m.dial
+	var sb strings.Builder
+	sb.WriteString("\n")
+	switch {
+	case !d.done:
+		fmt.Fprintf(&sb, "%s %s %s (%s, esc to cancel)\n", m.spinner.View(), strings.ToUpper(d.stage[:1])+d.stage[1:],
+			d.target, time.Since(d.started).Round(time.Second))
+	case d.err != nil:
+		sb.WriteString(ErrorStyle.Render(fmt.Sprintf("Connect to %s failed after %s: %v", d.target,
+			d.finished.Sub(d.started).Round(100*time.Millisecond), d.err)) + "\n")
+	default:
+		sb.WriteString(NoticeStyle.Render(d.outcome) + "\n")
+	}
+	if len(d.tried) > 0 {
+		sb.WriteString(TimeStyle.Render("Addresses tried:") + "\n")
+		for _, addr := range d.tried {
+			sb.WriteString(TimeStyle.Render("  "+addr) + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/session"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// conversationsLoadedMsg redraws the sidebar once contacts and unread
+// counts are read from the history.
+type conversationsLoadedMsg struct{}
+
+func (m Model) loadConversationsCmd() tea.Cmd {
+	return func() tea.Msg {
+		if err := m.session.LoadContacts(); err != nil {
+			log.Error("failed to load contacts", "err", err)
+		}
+		if err := m.session.LoadConversations(); err != nil {
+			log.Error("failed to load conversations", "err", err)
+		}
+		return conversationsLoadedMsg{}
+	}
+}
+
+// openConversation shows peer's conversation and marks it read.
+func (m *Model) openConversation(peer string) {
+	m.activePeer = peer
+	m.session.Open(peer)
+	m.thread = nil
+	m.selected = -1
+	m.cancelReply()
+	m.loadMessages()
+}
+
+// jumpToUnread handles alt+a: it opens the most recently active
+// conversation with unread messages, if there is one.
+func (m *Model) jumpToUnread() bool {
+	peer, ok := m.session.NextUnread()
+	if !ok {
+		return false
+	}
+	m.openConversation(peer)
+	m.updateView()
+	return true
+}
+
+// unreadBadge is the unread count shown next to a conversation, or "".
+func (m Model) unreadBadge(p string) string {
+	n := m.session.Unread(p)
+	switch {
+	case n == 0:
+		return ""
+	case n > 99:
+		return UnreadStyle.Render("(99+)")
+	}
+	return UnreadStyle.Render(fmt.Sprintf("(%d)", n))
+}
+
+// setNickname handles /nick <peer> [name]: it names a peer known by Peer ID
+// or nickname, or without a name removes its nickname.
+func setNickname(s *session.Session, arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "Usage: /nick <peer> [name], where name is one word"
+	}
+	p, ok := s.FindPeer(fields[0])
+	if !ok {
+		return fmt.Sprintf("No known peer called %s.", fields[0])
+	}
+	if len(fields) == 1 {
+		if err := s.SetNickname(p, ""); err != nil {
+			return fmt.Sprintf("Failed to remove the nickname: %v", err)
+		}
+		return fmt.Sprintf("Removed the nickname of %s.", fields[0])
+	}
+	nick := fields[1]
+	if other, ok := s.FindPeer(nick); ok && other != p {
+		return fmt.Sprintf("%s already names another peer.", nick)
+	}
+	if err := s.SetNickname(p, nick); err != nil {
+		return fmt.Sprintf("Failed to set the nickname: %v", err)
+	}
+	return fmt.Sprintf("%s is now called %s.", fields[0], nick)
+}
@@ -0,0 +1,176 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"shellchat/p2p"
+	"shellchat/session"
+	"shellchat/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// sendFileTimeout bounds a whole /send, large files included.
+const sendFileTimeout = 30 * time.Minute
+
+type fileEventMsg p2p.FileEvent
+
+type fileSentMsg struct {
+	peerID string
+	offer  p2p.FileOffer
+	err    error
+}
+
+// sendFileCmd handles /send. Files go to one peer, never the global room.
+func (m *Model) sendFileCmd(path string) tea.Cmd {
+	if m.activePeer == session.GlobalRoom {
+		m.viewport.SetContent("Open a conversation with a peer before sending a file.")
+		return nil
+	}
+	if path == "" {
+		m.viewport.SetContent("Usage: /send <path>")
+		return nil
+	}
+	path = expandHome(path)
+
+	host, peerID := m.host, m.activePeer
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), sendFileTimeout)
+		defer cancel()
+		offer, err := host.SendFile(ctx, peerID, path)
+		return fileSentMsg{peerID: peerID, offer: offer, err: err}
+	}
+}
+
+func (m *Model) handleFileSent(msg fileSentMsg) tea.Cmd {
+	if msg.err != nil {
+		log.Warn("file send failed", "peer", msg.peerID, "err", msg.err)
+		m.viewport.SetContent(ErrorStyle.Render(fmt.Sprintf("Sending failed: %v", msg.err)))
+		return nil
+	}
+	if err := m.session.FileSent(msg.peerID, msg.offer); err != nil {
+		log.Error("failed to save sent file", "err", err)
+	}
+	if msg.peerID == m.activePeer {
+		return m.loadHistoryCmd()
+	}
+	return nil
+}
+
+// handleFileEvent tracks transfers for the progress bars. Incoming files
+// are saved by the session in listenForPeerEvents before they get here.
+func (m *Model) handleFileEvent(e p2p.FileEvent) tea.Cmd {
+	key := e.Peer + "/" + e.ID
+	if e.Offered {
+		m.viewport.SetContent(NoticeStyle.Render(fmt.Sprintf("%s offers the file %s, %s. /accept or /decline it.", shortID(e.Peer), e.Name, p2p.FormatSize(e.Size))))
+		return nil
+	}
+	if !e.Finished {
+		m.transfers[key] = e
+		m.updateView()
+		return nil
+	}
+	delete(m.transfers, key)
+	m.updateView()
+	if e.Incoming && e.Err != nil && !errors.Is(e.Err, p2p.ErrOfferDeclined) {
+		m.viewport.SetContent(ErrorStyle.Render(fmt.Sprintf("Receiving %s from %s failed: %v", e.Name, shortID(e.Peer), e.Err)))
+	}
+	if e.Incoming && e.Err == nil && session.Shows(m.activePeer, e.Peer) {
+		return m.loadHistoryCmd()
+	}
+	return nil
+}
+
+// answerOffer handles /accept and /decline: it answers the latest file
+// offer, or the latest of a file called name.
+func answerOffer(s *session.Session, name string, accept bool) string {
+	e, ok := s.Offer(name)
+	if !ok {
+		if name == "" {
+			return "No file offers are waiting."
+		}
+		return fmt.Sprintf("No file offer called %s.", name)
+	}
+	if err := s.AnswerOffer(e, accept); err != nil {
+		return fmt.Sprintf("Failed to answer the offer: %v", err)
+	}
+	if accept {
+		return fmt.Sprintf("Receiving %s.", e.Name)
+	}
+	return fmt.Sprintf("Declined %s.", e.Name)
+}
+
+// saveFile handles /save <name>: it decrypts the latest file called name in
+// messages, the active conversation, into ~/Downloads, or the working
+// directory when there is none.
+func saveFile(s *session.Session, messages []storage.Message, name string) string {
+	if name == "" {
+		return "Usage: /save <file name>"
+	}
+	dir := expandHome("~/Downloads")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = "."
+	}
+
+	var file *storage.File
+	for i := len(messages) - 1; i >= 0 && file == nil; i-- {
+		if f := messages[i].File; f != nil && f.Stored && f.Name == name {
+			file = f
+		}
+	}
+	if file == nil {
+		return fmt.Sprintf("No received file called %s in this conversation.", name)
+	}
+
+	dst := filepath.Join(dir, file.Name)
+	if err := s.ExportFile(file.ID, dst); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Sprintf("%s already exists.", dst)
+		}
+		return fmt.Sprintf("Failed to save: %v", err)
+	}
+	return fmt.Sprintf("Saved %s", dst)
+}
+
+// renderTransfers draws a progress bar per running transfer.
+func (m Model) renderTransfers() string {
+	keys := make([]string, 0, len(m.transfers))
+	for k := range m.transfers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		e := m.transfers[k]
+		arrow := "↑"
+		if e.Incoming {
+			arrow = "↓"
+		}
+		pct := 100
+		if e.Size > 0 {
+			pct = int(e.Done * 100 / e.Size)
+		}
+		const width = 20
+		bar := strings.Repeat("█", pct*width/100) + strings.Repeat("░", width-pct*width/100)
+		sb.WriteString(NoticeStyle.Render(fmt.Sprintf("%s %s %s", arrow, e.Name, shortID(e.Peer))) + "\n")
+		sb.WriteString(fmt.Sprintf("  %s %3d%% %s / %s\n", InactiveStyle.Render(bar), pct, p2p.FormatSize(e.Done), p2p.FormatSize(e.Size)))
+	}
+	return sb.String()
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,290 @@
+package ui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// focusArea is the pane that receives keys without a global binding.
+type focusArea int
+
+const (
+	focusComposer focusArea = iota
+	focusSidebar
+	focusViewport
+)
+
+// keyMap holds the chat screen bindings. Keys in config.json replace the
+// defaults of an action by its name in bindings.
+type keyMap struct {
+	FocusNext  key.Binding
+	FocusPrev  key.Binding
+	Up         key.Binding
+	Down       key.Binding
+	Open       key.Binding
+	Jump       key.Binding // the nth key opens the nth conversation
+	NextUnread key.Binding
+	Select     key.Binding
+	Palette    key.Binding
+	Editor     key.Binding
+	Help       key.Binding
+	Lock       key.Binding
+	Back       key.Binding
+	Quit       key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		FocusNext:  key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "complete, or next pane")),
+		FocusPrev:  key.NewBinding(key.WithKeys("shift+tab"), key.WithHelp("shift+tab", "previous pane")),
+		Up:         key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "previous contact")),
+		Down:       key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "next contact")),
+		Open:       key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "open conversation")),
+		Jump:       key.NewBinding(key.WithKeys("alt+1", "alt+2", "alt+3", "alt+4", "alt+5", "alt+6", "alt+7", "alt+8", "alt+9"), key.WithHelp("alt+1…9", "open nth conversation")),
+		NextUnread: key.NewBinding(key.WithKeys("alt+a"), key.WithHelp("alt+a", "next unread")),
+		Select:     key.NewBinding(key.WithKeys("alt+up", "ctrl+up"), key.WithHelp("alt+↑", "select messages")),
+		Palette:    key.NewBinding(key.WithKeys("ctrl+p"), key.WithHelp("ctrl+p", "command palette")),
+		Editor:     key.NewBinding(key.WithKeys("ctrl+e"), key.WithHelp("ctrl+e", "compose in $EDITOR")),
+		Help:       key.NewBinding(key.WithKeys("f1"), key.WithHelp("f1", "key bindings")),
+		Lock:       key.NewBinding(key.WithKeys("ctrl+l"), key.WithHelp("ctrl+l", "lock")),
+		Back:       key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back, twice to quit")),
+		Quit:       key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "quit")),
+	}
+}
+
+// bindings maps the names used in config.json to the bindings.
+func (k *keyMap) bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"focus_next":  &k.FocusNext,
+		"focus_prev":  &k.FocusPrev,
+		"up":          &k.Up,
+		"down":        &k.Down,
+		"open":        &k.Open,
+		"jump":        &k.Jump,
+		"next_unread": &k.NextUnread,
+		"select":      &k.Select,
+		"palette":     &k.Palette,
+		"editor":      &k.Editor,
+		"help":        &k.Help,
+		"lock":        &k.Lock,
+		"back":        &k.Back,
+		"quit":        &k.Quit,
+	}
+}
+
+// newKeyMap applies the overrides from config.json to the defaults.
+// Unknown actions and empty key lists are logged and ignored.
+func newKeyMap(overrides map[string][]string) keyMap {
+	km := defaultKeyMap()
+	bindings := km.bindings()
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := overrides[name]
+		b, ok := bindings[name]
+		if !ok || len(keys) == 0 {
+			log.Warn("invalid key binding in config, ignored", "action", name, "keys", keys)
+			continue
+		}
+		b.SetKeys(keys...)
+		b.SetHelp(strings.Join(keys, "/"), b.Help().Desc)
+	}
+	return km
+}
+
+// ShortHelp and FullHelp implement help.KeyMap.
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.FocusNext, k.Help, k.Back}
+}
+
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.FocusNext, k.FocusPrev, k.Up, k.Down, k.Open, k.Jump, k.NextUnread},
+		{k.Select, k.Palette, k.Editor, k.Help, k.Lock, k.Back, k.Quit},
+	}
+}
+
+// composerHelp lists the composer keys, which cannot be rebound.
+const composerHelp = "enter send · alt+enter new line · ↑/↓ history"
+
+// handleChatKey runs the bindings of the chat screen. It reports false for
+// keys that should reach the composer.
+func (m *Model) handleChatKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	switch {
+	case m.showHelp:
+		m.showHelp = false
+		return nil, true
+	case m.selected >= 0:
+		return m.handleSelectionKey(msg), true
+	case m.palette != nil:
+		return m.handlePaletteKey(msg), true
+	}
+	// Letters typed in the composer are text, whatever else they are bound to
+	if m.focus == focusComposer && msg.Type == tea.KeyRunes && !msg.Alt {
+		return nil, false
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Help):
+		m.showHelp = true
+	case key.Matches(msg, m.keys.FocusNext, m.keys.FocusPrev):
+		forward := key.Matches(msg, m.keys.FocusNext)
+		if m.focus == focusComposer && m.complete(forward) {
+			return nil, true
+		}
+		return m.cycleFocus(forward), true
+	case key.Matches(msg, m.keys.Jump):
+		m.jumpTo(msg.String())
+	case key.Matches(msg, m.keys.NextUnread):
+		if m.editing == nil {
+			m.jumpToUnread()
+		}
+	case key.Matches(msg, m.keys.Select):
+		if m.editing != nil || !m.startSelection() {
+			return nil, m.focus != focusComposer
+		}
+	case key.Matches(msg, m.keys.Palette):
+		m.openPalette()
+	case key.Matches(msg, m.keys.Editor):
+		return openEditorCmd(m.messageIn.Value()), true
+	case key.Matches(msg, m.keys.Back):
+		return m.back(), true
+	case m.focus == focusSidebar:
+		return m.handleSidebarKey(msg), true
+	case m.focus == focusViewport:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return cmd, true
+	default:
+		if (msg.Type == tea.KeyUp || msg.Type == tea.KeyDown) && !msg.Alt && m.editing == nil {
+			return nil, m.recallHistory(msg)
+		}
+		return nil, false
+	}
+	return nil, true
+}
+
+// cycleFocus moves the focus to the next or previous pane, left to right
+// and then down to the composer.
+func (m *Model) cycleFocus(forward bool) tea.Cmd {
+	order := []focusArea{focusSidebar, focusViewport, focusComposer}
+	i := 0
+	for j, f := range order {
+		if f == m.focus {
+			i = j
+		}
+	}
+	if forward {
+		i = (i + 1) % len(order)
+	} else {
+		i = (i + len(order) - 1) % len(order)
+	}
+	return m.setFocus(order[i])
+}
+
+func (m *Model) setFocus(f focusArea) tea.Cmd {
+	m.focus = f
+	if f == focusSidebar {
+		m.sidebarCursor = m.activePeer
+	}
+	if f == focusComposer {
+		return m.messageIn.Focus()
+	}
+	m.messageIn.Blur()
+	return nil
+}
+
+// handleSidebarKey moves the contact cursor and opens the conversation
+// under it. The cursor follows a peer rather than a row, so it stays put
+// when activity reorders the list.
+func (m *Model) handleSidebarKey(msg tea.KeyMsg) tea.Cmd {
+	conversations := m.session.Conversations()
+	i := 0
+	for j, p := range conversations {
+		if p == m.sidebarCursor {
+			i = j
+		}
+	}
+	switch {
+	case key.Matches(msg, m.keys.Up):
+		i = max(i-1, 0)
+	case key.Matches(msg, m.keys.Down):
+		i = min(i+1, len(conversations)-1)
+	case key.Matches(msg, m.keys.Open):
+		m.openConversation(conversations[i])
+		m.updateView()
+		return m.setFocus(focusComposer)
+	}
+	m.sidebarCursor = conversations[i]
+	return nil
+}
+
+// jumpTo opens the conversation at the position of k among the Jump keys.
+// The global room is the first.
+func (m *Model) jumpTo(k string) {
+	conversations := m.session.Conversations()
+	for i, jk := range m.keys.Jump.Keys() {
+		if jk == k && i < len(conversations) {
+			m.openConversation(conversations[i])
+			m.updateView()
+			return
+		}
+	}
+}
+
+// back handles esc: it cancels what is in progress or returns to the
+// composer, and only then asks whether to quit.
+func (m *Model) back() tea.Cmd {
+	switch {
+	case m.editing != nil:
+		m.cancelEdit()
+		m.updateView()
+	case m.replyTo != nil:
+		m.cancelReply()
+	case m.thread != nil:
+		m.closeThread()
+	case m.cancelDial():
+		// Esc cancels a running /connect before anything else
+		m.updateView()
+	case m.focus != focusComposer:
+		return m.setFocus(focusComposer)
+	default:
+		m.confirmQuit = true
+	}
+	return nil
+}
+
+// helpView lists the key bindings. It replaces the chat pane while open.
+func (m Model) helpView() string {
+	h := help.New()
+	h.ShowAll = true
+	h.Width = m.viewport.Width
+	h.Styles.FullKey = NoticeStyle
+	h.Styles.FullDesc = InactiveStyle
+	h.Styles.FullSeparator = TimeStyle
+
+	var sb strings.Builder
+	sb.WriteString(NoticeStyle.Render("KEY BINDINGS") + TimeStyle.Render("  any key closes") + "\n\n")
+	sb.WriteString(h.View(m.keys) + "\n\n")
+	sb.WriteString(TimeStyle.Render("Composer: "+composerHelp) + "\n")
+	sb.WriteString(TimeStyle.Render("Selection: "+selectionHelp) + "\n")
+	return sb.String()
+}
+
+// sidebarMarker prefixes the contact under the sidebar cursor.
+func (m Model) sidebarMarker(p string) string {
+	if m.focus == focusSidebar && p == m.sidebarCursor {
+		return UnreadStyle.Render("▸")
+	}
+	if p == m.activePeer {
+		return ActiveStyle.Render(">")
+	}
+	return " "
+}
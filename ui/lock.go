@@ -0,0 +1,48 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// lock closes the history and returns to the password screen. Everything
+// decrypted goes with it: the messages on screen, the draft and the
+// composer history. Incoming messages wait, sealed, in the session.
+func (m *Model) lock() tea.Cmd {
+	if err := m.session.Lock(); err != nil {
+		log.Error("failed to lock", "err", err)
+		m.viewport.SetContent(fmt.Sprintf("Error: %v", err))
+		return nil
+	}
+	m.state = stateAuth
+	m.locked = true
+	m.err = nil
+	m.messages = nil
+	m.thread = nil
+	m.selected = -1
+	m.editing = nil
+	m.replyTo = nil
+	m.palette = nil
+	m.completion = nil
+	m.showHelp = false
+	m.showLogs = false
+	m.md = &markdown{}
+	m.messageIn.Reset()
+	m.history = nil
+	m.stopRecall()
+	m.setFocus(focusComposer)
+	m.viewport.SetContent("")
+	m.passwordIn.SetValue("")
+	return m.passwordIn.Focus()
+}
+
+// checkLock locks after the configured time without input.
+func (m *Model) checkLock() tea.Cmd {
+	if m.lockAfter == 0 || m.state != stateChat || time.Since(m.lastInput) < m.lockAfter {
+		return nil
+	}
+	log.Info("locking after inactivity", "idle", m.lockAfter)
+	return m.lock()
+}
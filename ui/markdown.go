@@ -0,0 +1,143 @@
+package ui
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"shellchat/storage"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// bodyIndent sets off message bodies that don't fit on the header line.
+const bodyIndent = "  "
+
+// maxRenderCache bounds how many rendered messages markdown keeps.
+const maxRenderCache = 500
+
+var (
+	urlPattern = regexp.MustCompile(`https?://[^\s\x1b<>"]+`)
+	// trailingPad matches the styled spaces glamour pads each line with.
+	trailingPad = regexp.MustCompile(`(?:\x1b\[[0-9;]*m| )+$`)
+)
+
+// markdown renders message bodies for one pane width and remembers the
+// results, since the view is redrawn far more often than messages change.
+// It is shared by copies of the Model.
+type markdown struct {
+	width    int
+	light    bool // rendered for a light theme
+	renderer *glamour.TermRenderer
+	cache    map[string]string
+}
+
+// render returns content as terminal Markdown no wider than width, or
+// ok=false if glamour fails on it.
+func (md *markdown) render(content string, width int) (out string, ok bool) {
+	if md.renderer == nil || md.width != width || md.light != lightTheme {
+		r, err := newRenderer(width, lightTheme)
+		if err != nil {
+			log.Warn("failed to create markdown renderer", "err", err)
+			return "", false
+		}
+		md.width, md.light, md.renderer, md.cache = width, lightTheme, r, make(map[string]string)
+	}
+	if out, ok := md.cache[content]; ok {
+		return out, true
+	}
+
+	out, err := md.renderer.Render(content)
+	if err != nil {
+		log.Debug("failed to render markdown", "err", err)
+		return "", false
+	}
+	out = trimRendered(out)
+	if len(md.cache) >= maxRenderCache {
+		md.cache = make(map[string]string)
+	}
+	md.cache[content] = out
+	return out, true
+}
+
+// newRenderer sets up glamour's dark or light style without its document
+// margins so bodies line up with the rest of the pane. Newlines are kept as
+// typed, as chat users expect.
+func newRenderer(width int, light bool) (*glamour.TermRenderer, error) {
+	style := styles.DarkStyleConfig
+	if light {
+		style = styles.LightStyleConfig
+	}
+	var margin uint
+	style.Document.Margin = &margin
+	style.Document.BlockPrefix = ""
+	style.Document.BlockSuffix = ""
+	style.Document.Color = nil
+	return glamour.NewTermRenderer(
+		glamour.WithStyles(style),
+		glamour.WithColorProfile(lipgloss.ColorProfile()),
+		glamour.WithWordWrap(width),
+		glamour.WithPreservedNewLines(),
+	)
+}
+
+// trimRendered drops the padding glamour adds to the right of each line and
+// the blank lines around the document.
+func trimRendered(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if trimmed := trailingPad.ReplaceAllString(line, ""); trimmed != line {
+			lines[i] = trimmed + ansi.ResetStyle
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[0])) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[len(lines)-1])) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sanitize removes control characters a peer could use to drive our
+// terminal. Tabs become spaces so they take a known width.
+func sanitize(s string) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// linkify makes URLs clickable in terminals that support OSC 8 hyperlinks.
+func linkify(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(url string) string {
+		trimmed := strings.TrimRight(url, ".,;:!?)]'")
+		rest := url[len(trimmed):]
+		return ansi.SetHyperlink(trimmed) + trimmed + ansi.ResetHyperlink() + rest
+	})
+}
+
+// messageBody lays out the header and body of a message within width. The
+// body goes on the header line when it fits there and on the lines below
+// otherwise.
+func (m *Model) messageBody(header string, msg storage.Message, width int) string {
+	text := messageText(msg)
+	if !m.rawText && msg.File == nil && !msg.Deleted {
+		if body, ok := m.md.render(sanitize(msg.Content), width-len(bodyIndent)); ok {
+			if msg.EditedAt != 0 {
+				body += TimeStyle.Render(" (edited)")
+			}
+			if !strings.Contains(body, "\n") && ansi.StringWidth(header)+ansi.StringWidth(body) <= width {
+				return linkify(header + body)
+			}
+			return linkify(strings.TrimRight(header, " ") + "\n" + bodyIndent + strings.ReplaceAll(body, "\n", "\n"+bodyIndent))
+		}
+	}
+	return linkify(ansi.Wrap(header+text, width, ""))
+}
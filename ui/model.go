@@ -1,25 +1,31 @@
 package ui
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
+	"shellchat/commands"
+	"shellchat/config"
+	"shellchat/logging"
 	"shellchat/p2p"
+	"shellchat/session"
 	"shellchat/storage"
+	"shellchat/theme"
 
-	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/multiformats/go-multiaddr"
-	"golang.org/x/crypto/argon2"
+	"github.com/libp2p/go-libp2p/core/event"
 )
 
+var log = logging.Logger("ui")
+
 type sessionState int
 
 const (
@@ -27,25 +33,89 @@ const (
 	stateChat
 )
 
+// quitPrompt asks for a second esc before quitting.
+const quitPrompt = "Press esc again to quit"
+
+// wipePrompt asks for a second /wipe before deleting the history.
+const wipePrompt = "This deletes every message and received file on this device. Type /wipe again to confirm."
+
+const messagePlaceholder = "Type a message... (/myid | /connect <addr>)"
+
 type Model struct {
 	state      sessionState
 	passwordIn textinput.Model
-	messageIn  textinput.Model
+	messageIn  textarea.Model
 	viewport   viewport.Model
 	messages   []storage.Message
 	err        error
+	cfg        *config.Config
 
-	// P2P
+	// P2P; the session owns conversations, contacts and sending
 	host       *p2p.ChatHost
+	session    *session.Session
+	events     event.Subscription
 	activePeer string
-	peers      []string
+	transfers  map[string]p2p.FileEvent // peer/file id -> progress
+
+	// Notifications; focused is false while the terminal reports a blur
+	notifyMethod string
+	term         *terminal // the program output, shared with notifications
+	focused      bool
+
+	// Auto-away and auto-lock; locked is set on the password screen after
+	// a lock
+	idleAfter time.Duration
+	lockAfter time.Duration
+	lastInput time.Time
+	autoAway  bool
+	locked    bool
+
+	// Composer history (↑/↓); historyPos is len(history) when not recalling
+	history    []string
+	historyPos int
+	draft      string
+	completion *completion
+	palette    *palette
+
+	// Key bindings and focus; sidebarCursor is the contact under the
+	// cursor while the sidebar has focus
+	keys          keyMap
+	focus         focusArea
+	sidebarCursor string
+	showHelp      bool
+	confirmQuit   bool // esc was pressed once with nothing to cancel
+	confirmWipe   bool // the last input was a first /wipe
+
+	// Selection mode, message editing and replies
+	selected int // index into messages, -1 when not selecting
+	editing  *storage.Message
+	replyTo  *storage.Message
+	thread   []storage.Message // shown instead of the conversation when set
+
+	// Message rendering; /raw shows bodies as typed
+	md        *markdown
+	rawText   bool
+	themes    []theme.Theme
+	themeName string // the theme in use
+
+	// /connect progress
+	dial    *dialState
+	spinner spinner.Model
+
+	// Log pane (/logs)
+	showLogs bool
+	logSeq   uint64
 
 	// Layout
 	width  int
 	height int
 }
 
-func InitialModel(host *p2p.ChatHost) Model {
+func InitialModel(host *p2p.ChatHost, cfg *config.Config) Model {
+	themes := loadThemes()
+	current := configuredTheme(themes, cfg.Theme)
+	useTheme(current)
+
 	ti := textinput.New()
 	ti.Placeholder = "Enter master password"
 	ti.EchoMode = textinput.EchoPassword
@@ -55,55 +125,108 @@ func InitialModel(host *p2p.ChatHost) Model {
 	ti.PromptStyle = lipgloss.NewStyle().Foreground(ColorGreen)
 	ti.TextStyle = lipgloss.NewStyle().Foreground(ColorGreen)
 
-	mi := textinput.New()
-	mi.Placeholder = "Type a message... (/myid | /connect <addr>)"
-	mi.Focus()
-	mi.CharLimit = 1000
-	mi.Width = 80
-	mi.PromptStyle = lipgloss.NewStyle().Foreground(ColorGreen)
-	mi.TextStyle = lipgloss.NewStyle().Foreground(ColorGreen)
-
 	vp := viewport.New(80, 20)
 	vp.SetContent("Welcome to ShellChat.\nUnlock to start.")
 
+	sp := spinner.New()
+	sp.Spinner = spinner.Dot
+	sp.Style = lipgloss.NewStyle().Foreground(ColorGreen)
+
+	var events event.Subscription
+	var sessionHost session.Host
+	if host != nil {
+		sub, err := host.SubscribeEvents()
+		if err != nil {
+			log.Error("failed to subscribe to peer events", "err", err)
+		}
+		events = sub
+		sessionHost = host
+	}
+
 	return Model{
 		state:      stateAuth,
 		passwordIn: ti,
-		messageIn:  mi,
+		messageIn:  newComposer(),
 		viewport:   vp,
+		cfg:        cfg,
 		host:       host,
-		activePeer: "global-room",
-		peers:      []string{"global-room"},
+		session:    session.New(sessionHost, cfg),
+		events:     events,
+		activePeer: session.GlobalRoom,
+		transfers:  make(map[string]p2p.FileEvent),
+		selected:   -1,
+		md:         &markdown{},
+		idleAfter:  parseIdleTime("presence.auto_away", cfg.Presence.AutoAway),
+		lockAfter:  parseIdleTime("privacy.auto_lock", cfg.Privacy.AutoLock),
+		lastInput:  time.Now(),
+		spinner:    sp,
+		keys:       newKeyMap(cfg.Keys),
+		themes:     themes,
+		themeName:  current.Name,
+
+		focused:      true,
+		notifyMethod: notifyMethod(cfg.Notifications.Method),
+		term:         &terminal{File: os.Stdout},
 	}
 }
 
+// NewProgram returns the TUI program, rendering to the standard output.
+func NewProgram(host *p2p.ChatHost, cfg *config.Config) *tea.Program {
+	m := InitialModel(host, cfg)
+	// Focus reports let the TUI notify only while it is in the background
+	return tea.NewProgram(m, tea.WithOutput(m.term), tea.WithReportFocus())
+}
+
 func (m Model) Init() tea.Cmd {
 	return tea.Batch(
-		textinput.Blink,
+		textarea.Blink,
 		m.listenForP2PMessages(),
+		m.listenForPeerEvents(),
+		idleTickCmd(),
 	)
 }
 
+func (m Model) listenForPeerEvents() tea.Cmd {
+	return func() tea.Msg {
+		if m.events == nil {
+			return nil
+		}
+		e, ok := <-m.events.Out()
+		if !ok {
+			return nil
+		}
+		if fe, ok := e.(p2p.FileEvent); ok {
+			m.session.ReceiveFile(fe)
+			return fileEventMsg(fe)
+		}
+		return peerEventMsg(e.(p2p.PeerEvent))
+	}
+}
+
 func (m Model) listenForP2PMessages() tea.Cmd {
 	return func() tea.Msg {
 		if m.host == nil {
 			return nil
 		}
-		msg := <-m.host.MsgChan
-		parts := strings.SplitN(msg, "|", 2)
-		if len(parts) == 2 {
-			peerID := parts[0]
-			content := parts[1]
-			if storage.DB != nil {
-				storage.SaveMessage(peerID, content, time.Now().Unix(), false)
+		for in := range m.host.MsgChan {
+			if ev, ok := m.session.Receive(in); ok {
+				return sessionEventMsg(ev)
 			}
-			return p2pMsg{peerID: peerID, content: content}
 		}
 		return nil
 	}
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	nm := next.(Model)
+	if nm.state == stateChat {
+		nm.resizeComposer()
+	}
+	return nm, cmd
+}
+
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
@@ -111,23 +234,38 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 		// Responsive resizing
-		m.viewport.Width = m.width - 25  // Sidebar approx 20-25
-		m.viewport.Height = m.height - 5 // Header/Footer buffer
-		m.messageIn.Width = m.width - 5
+		m.viewport.Width = m.width - 27                         // Sidebar approx 20-25, plus the pane's padding
+		m.viewport.Height = m.height - 4 - m.messageIn.Height() // Header/Footer buffer
+		m.messageIn.SetWidth(m.width - 7)
+		if m.state == stateChat {
+			m.updateView()
+		}
 
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		m.markActive()
+		quitting := m.confirmQuit
+		m.confirmQuit = false
+		if key.Matches(msg, m.keys.Quit) || quitting && key.Matches(msg, m.keys.Back) {
 			return m, tea.Quit
+		}
+		if m.state == stateChat && key.Matches(msg, m.keys.Lock) {
+			return m, m.lock()
+		}
+		if m.state == stateChat {
+			if cmd, ok := m.handleChatKey(msg); ok {
+				return m, cmd
+			}
+		} else if key.Matches(msg, m.keys.Back) {
+			m.confirmQuit = true
+			return m, nil
+		}
+		switch msg.Type {
 		case tea.KeyEnter:
+			if msg.Alt {
+				break // newline in the composer
+			}
 			if m.state == stateAuth {
 
-				// Unlock DB
-				password := m.passwordIn.Value()
-				salt := []byte("shellchat-static-salt")
-				key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-				hexKey := fmt.Sprintf("x'%x'", key)
-
 				userConfigDir, err := os.UserConfigDir()
 				if err != nil {
 					m.err = err
@@ -135,7 +273,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 
-				if err := storage.InitDB(userConfigDir, hexKey); err != nil {
+				if err := m.session.Unlock(userConfigDir, m.passwordIn.Value()); err != nil {
+					log.Warn("unlock failed", "err", err)
 					m.err = err
 					m.viewport.SetContent(fmt.Sprintf("Error: %v\nTry again.", err))
 					m.passwordIn.SetValue("")
@@ -143,177 +282,164 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 
 				m.state = stateChat
+				m.locked = false
+				m.passwordIn.SetValue("")
 				m.viewport.SetContent("Locating peers...")
-				return m, tea.Batch(m.loadHistoryCmd(), m.findPeersCmd())
+				return m, tea.Batch(m.loadHistoryCmd(), m.findPeersCmd(), m.loadConversationsCmd())
 
 			} else {
 				// Chat or Command
-				content := m.messageIn.Value()
-				if content == "" {
-					return m, nil
-				}
-
-				// Command: /myid
-				if content == "/myid" {
-					var addrs []string
-					for _, addr := range m.host.P2PHost.Addrs() {
-						addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", addr, m.host.P2PHost.ID()))
-					}
-					m.viewport.SetContent(fmt.Sprintf("My Addresses:\n%s", strings.Join(addrs, "\n")))
-					m.messageIn.SetValue("")
-					return m, nil
+				content := strings.TrimRight(m.messageIn.Value(), " \t\n")
+				if m.editing != nil {
+					return m, m.finishEdit(content)
 				}
-
-				// Command: /copyid
-				if content == "/copyid" {
-					var addrs []string
-					for _, addr := range m.host.P2PHost.Addrs() {
-						addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", addr, m.host.P2PHost.ID()))
-					}
-					fullAddr := strings.Join(addrs, "\n")
-					if err := clipboard.WriteAll(fullAddr); err != nil {
-						m.viewport.SetContent(fmt.Sprintf("Failed to copy: %v", err))
-					} else {
-						m.viewport.SetContent("Addresses copied to clipboard!")
-					}
-					m.messageIn.SetValue("")
+				if strings.TrimSpace(content) == "" {
 					return m, nil
 				}
-
-				// Command: /help
-				if content == "/help" {
-					helpText := `
-COMMANDS
---------
-/myid           - Show your P2P addresses
-/copyid         - Copy your addresses to clipboard
-/connect <addr> - Connect to a peer by address
-/exit           - Return to global room
-/clear          - Clear chat history
-/quit           - Exit application
-/help           - Show this help message
-`
-					m.viewport.SetContent(helpText)
-					m.messageIn.SetValue("")
-					return m, nil
+				m.remember(content)
+				// Only a /wipe right after the first one confirms it
+				if name, _ := commands.Parse(content); !commands.IsCommand(content) || name != "wipe" {
+					m.confirmWipe = false
 				}
 
-				// Command: /exit
-				if content == "/exit" {
-					m.activePeer = "global-room"
-					m.messages, _ = storage.GetMessages(m.activePeer, 50)
-					m.updateView()
+				if commands.IsCommand(content) {
 					m.messageIn.SetValue("")
-					return m, nil
+					return m, m.runCommand(content)
 				}
 
-				// Command: /quit
-				if content == "/quit" {
-					return m, tea.Quit
+				// Send (a finished /connect report has served its purpose)
+				if m.dial != nil && m.dial.done {
+					m.dial = nil
 				}
-
-				// Command: /clear
-				if content == "/clear" {
-					m.messages = []storage.Message{}
-					m.updateView()
-					m.messageIn.SetValue("")
-					return m, nil
+				var replyTo string
+				if m.replyTo != nil {
+					replyTo = m.replyTo.MsgID
+					m.cancelReply()
 				}
-
-				// Command: /connect <multiaddr> OR <peerID>
-				if strings.HasPrefix(content, "/connect ") {
-					addrStr := strings.TrimPrefix(content, "/connect ")
-					m.viewport.SetContent(fmt.Sprintf("Connecting to %s...", addrStr))
-
-					// 1. Try valid Multiaddr
-					ma, err := multiaddr.NewMultiaddr(addrStr)
-					if err == nil {
-						pi, err := peer.AddrInfoFromP2pAddr(ma)
-						if err == nil {
-							// Connect in background
-							go func() {
-								ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-								defer cancel()
-								if err := m.host.P2PHost.Connect(ctx, *pi); err != nil {
-									// In a real app, send callback msg to UI
-								}
-							}()
-							m.addPeer(pi.ID.String())
-							m.activePeer = pi.ID.String()
-							m.messages, _ = storage.GetMessages(m.activePeer, 50)
-							m.updateView()
-							m.messageIn.SetValue("")
-							return m, nil
-						}
-					}
-
-					// 2. Try Peer ID (DHT Lookup)
-					pid, err := peer.Decode(addrStr)
-					if err == nil {
-						m.viewport.SetContent(fmt.Sprintf("Looking up Peer ID %s in DHT...", pid.ShortString()))
-						go func() {
-							ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-							defer cancel()
-							pi, err := m.host.DHT.FindPeer(ctx, pid)
-							if err != nil {
-								// Send error to UI (not implemented in this simplified model, but at least we try)
-								return
-							}
-							if err := m.host.P2PHost.Connect(ctx, pi); err == nil {
-								// Connection success, UI will update on next interaction or we could send a Cmd
-							}
-						}()
-						m.addPeer(pid.String())
-						m.activePeer = pid.String()
-						m.messages, _ = storage.GetMessages(m.activePeer, 50)
-						m.updateView()
-						m.messageIn.SetValue("")
-						return m, nil
-					}
-
-					m.viewport.SetContent(fmt.Sprintf("Invalid address or Peer ID: %s", addrStr))
-					m.messageIn.SetValue("")
-					return m, nil
-				}
-
-				// Send
-				err := storage.SaveMessage(m.activePeer, content, time.Now().Unix(), true)
-				if err != nil {
+				if err := m.session.Send(m.activePeer, replyTo, commands.Unescape(content)); err != nil {
+					log.Error("failed to save sent message", "err", err)
 					m.viewport.SetContent(fmt.Sprintf("Error: %v", err))
 					return m, nil
 				}
 
-				// P2P Send
-				if m.host != nil {
-					// Broadcast loop (simplification)
-					for _, p := range m.host.P2PHost.Network().Peers() {
-						go func(pid string) {
-							m.host.SendMessage(context.Background(), pid, content)
-						}(p.String())
-					}
-				}
-
 				m.messageIn.SetValue("")
 				return m, m.loadHistoryCmd()
 			}
 		}
 
-	case p2pMsg:
-		m.addPeer(msg.peerID)
-		if msg.peerID == m.activePeer || m.activePeer == "global-room" {
-			return m, tea.Batch(m.loadHistoryCmd(), m.listenForP2PMessages())
+	case sessionEventMsg:
+		switch msg.Kind {
+		case session.EventTyping:
+			return m, tea.Batch(m.listenForP2PMessages(), typingExpireCmd())
+		case session.EventMessage:
+			var announce tea.Cmd
+			if n, ok := m.session.Notification(session.Event(msg), m.focused, time.Now()); ok {
+				announce = notifyCmd(m.term, m.notifyMethod, n)
+			}
+			if session.Shows(m.activePeer, msg.Peer) {
+				return m, tea.Batch(m.loadHistoryCmd(), m.listenForP2PMessages(), announce)
+			}
+			return m, tea.Batch(m.listenForP2PMessages(), announce)
+		case session.EventChanged:
+			if session.Shows(m.activePeer, msg.Peer) {
+				return m, tea.Batch(m.loadHistoryCmd(), m.listenForP2PMessages())
+			}
 		}
 		return m, m.listenForP2PMessages()
 
 	case historyMsg:
 		m.messages = msg.messages
+		if m.selected >= len(m.messages) {
+			m.selected = len(m.messages) - 1
+		}
+		m.updateView()
+
+	case threadMsg:
+		if msg.err != nil {
+			log.Error("failed to load thread", "err", msg.err)
+			m.viewport.SetContent(fmt.Sprintf("Error: %v", msg.err))
+			return m, nil
+		}
+		m.thread = msg.messages
 		m.updateView()
 
+	case errMsg:
+		log.Error("failed to load history", "err", msg.err)
+		m.viewport.SetContent(fmt.Sprintf("Error: %v", msg.err))
+
+	case logTickMsg:
+		if !m.showLogs {
+			return m, nil
+		}
+		if _, seq := logging.Recent(0); seq != m.logSeq {
+			m.updateView()
+		}
+		return m, logTickCmd()
+
 	case peersFoundMsg:
-		// Update peer list from DHT discovery
+		// Peers that connected before we subscribed to events
 		for _, p := range msg.peers {
-			m.addPeer(p)
+			m.session.HandlePeerEvent(p2p.PeerEvent{Kind: p2p.EventPeerConnected, Peer: p})
+		}
+
+	case tea.FocusMsg:
+		m.focused = true
+		return m, nil
+
+	case tea.BlurMsg:
+		m.focused = false
+		return m, nil
+
+	case conversationsLoadedMsg:
+		// Nothing to do but redraw the sidebar
+		return m, nil
+
+	case typingExpireMsg:
+		// Nothing to do but redraw without the indicator
+		return m, nil
+
+	case idleTickMsg:
+		m.checkIdle()
+		return m, tea.Batch(m.checkLock(), idleTickCmd())
+
+	case lookupResultMsg:
+		cmd := m.handleLookupResult(msg)
+		m.updateView()
+		return m, cmd
+
+	case connectResultMsg:
+		m.handleConnectResult(msg)
+		m.updateView()
+		return m, nil
+
+	case spinner.TickMsg:
+		if m.dial == nil || m.dial.done {
+			return m, nil
+		}
+		m.spinner, cmd = m.spinner.Update(msg)
+		m.updateView()
+		return m, cmd
+
+	case peerEventMsg:
+		m.session.HandlePeerEvent(p2p.PeerEvent(msg))
+		return m, m.listenForPeerEvents()
+
+	case fileEventMsg:
+		cmd := m.handleFileEvent(p2p.FileEvent(msg))
+		return m, tea.Batch(cmd, m.listenForPeerEvents())
+
+	case fileSentMsg:
+		return m, m.handleFileSent(msg)
+
+	case editorDoneMsg:
+		if msg.err != nil {
+			log.Warn("external editor failed", "err", msg.err)
+			m.viewport.SetContent(fmt.Sprintf("Error: %v", msg.err))
+			return m, nil
 		}
+		m.messageIn.SetValue(msg.text)
+		m.stopRecall()
+		return m, nil
 	}
 
 	if m.state == stateAuth {
@@ -321,65 +447,173 @@ COMMANDS
 		return m, cmd
 	}
 
+	before := m.messageIn.Value()
 	m.messageIn, cmd = m.messageIn.Update(msg)
+	if after := m.messageIn.Value(); after != before {
+		m.stopRecall()
+		m.session.NotifyTyping(m.activePeer, after)
+	}
 	return m, cmd
 }
 
+// typingLine describes who is typing in the active conversation.
+func (m Model) typingLine() string {
+	var nicks []string
+	for _, p := range m.session.Typing(m.activePeer) {
+		nicks = append(nicks, shortID(p))
+	}
+	switch len(nicks) {
+	case 0:
+		return ""
+	case 1:
+		return nicks[0] + " is typing…"
+	}
+	return strings.Join(nicks, ", ") + " are typing…"
+}
+
+// messageText is what the chat pane shows for a stored message.
+func messageText(msg storage.Message) string {
+	if msg.Deleted {
+		return TimeStyle.Italic(true).Render("message deleted")
+	}
+	if msg.File == nil {
+		if msg.EditedAt != 0 {
+			return sanitize(msg.Content) + TimeStyle.Render(" (edited)")
+		}
+		return sanitize(msg.Content)
+	}
+	name := sanitize(msg.File.Name)
+	text := fmt.Sprintf("[file] %s (%s)", name, p2p.FormatSize(msg.File.Size))
+	if msg.File.Stored {
+		text += TimeStyle.Render(" /save " + name)
+	}
+	return text
+}
+
+// renderMessage formats one message for the chat pane, preceded by a quote
+// of the message it answers. marker prefixes the message line.
+func (m *Model) renderMessage(msg storage.Message, marker string) string {
+	var sb strings.Builder
+	if q := quoteLine(msg); q != "" {
+		sb.WriteString(q + "\n")
+	}
+	timeStr := TimeStyle.Render(time.Unix(msg.Timestamp, 0).Format("15:04"))
+	prefix := ReceiverStyle.Render("THEM")
+	if msg.IsSent {
+		prefix = SenderStyle.Render("YOU")
+	}
+	header := fmt.Sprintf("%s[%s] %s: ", marker, timeStr, prefix)
+	sb.WriteString(m.messageBody(header, msg, m.viewport.Width) + "\n")
+	if r := reactionsLine(msg); r != "" {
+		sb.WriteString(r + "\n")
+	}
+	return sb.String()
+}
+
 func refreshView(m *Model) {
 	var sb strings.Builder
 	for _, msg := range m.messages {
-		timeStr := TimeStyle.Render(time.Unix(msg.Timestamp, 0).Format("15:04"))
-		prefix := ReceiverStyle.Render("THEM")
-		if msg.IsSent {
-			prefix = SenderStyle.Render("YOU")
-		}
-		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", timeStr, prefix, msg.Content))
+		sb.WriteString(m.renderMessage(msg, ""))
 	}
 	m.viewport.SetContent(sb.String())
 	m.viewport.GotoBottom()
 }
 
-func (m *Model) addPeer(p string) {
-	for _, existing := range m.peers {
-		if existing == p {
-			return
-		}
-	}
-	m.peers = append(m.peers, p)
-}
-
 func (m Model) View() string {
 	if m.state == stateAuth {
 		errStr := ""
 		if m.err != nil {
 			errStr = lipgloss.NewStyle().Foreground(ColorRed).Render(m.err.Error())
 		}
+		if m.confirmQuit {
+			errStr = quitPrompt
+		}
+		heading := "ENCRYPTED LINK"
+		if m.locked {
+			heading = "LOCKED"
+		}
 		return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
 			BorderStyle.Render(
-				fmt.Sprintf("%s\n\nENCRYPTED LINK\n\n%s\n\n%s", Logo, m.passwordIn.View(), errStr),
+				fmt.Sprintf("%s\n\n%s\n\n%s\n\n%s", Logo, heading, m.passwordIn.View(), errStr),
 			),
 		)
 	}
 
 	// Split View: Sidebar | Chat
 	sidebarContent := lipgloss.NewStyle().Foreground(ColorGreen).Render("CONTACTS\n--------\n")
-	for _, p := range m.peers {
-		if p == m.activePeer {
-			sidebarContent += ActiveStyle.Render("> "+p[:10]+"...") + "\n"
-		} else {
-			sidebarContent += InactiveStyle.Render("  "+p[:10]+"...") + "\n"
+	conversations := m.session.Conversations()
+	for _, p := range conversations {
+		dot := "  "
+		if p != session.GlobalRoom {
+			dot = m.presenceDot(p) + " "
+		}
+		marker := m.sidebarMarker(p) + " "
+		switch badge := m.unreadBadge(p); {
+		case p == m.activePeer:
+			sidebarContent += marker + dot + ActiveStyle.Render(p[:8]+"...") + "\n"
+		case badge != "":
+			sidebarContent += marker + dot + UnreadStyle.Render(p[:8]) + " " + badge + "\n"
+		default:
+			sidebarContent += marker + dot + InactiveStyle.Render(p[:8]+"...") + "\n"
+		}
+		if status := m.peerStatus(p, 14); status != "" {
+			sidebarContent += "    " + status + "\n"
 		}
 	}
 
-	sidebar := SidebarStyle.Width(20).Height(m.height - 7).Render(sidebarContent)
+	// The focused pane has a green border, the others gray
+	sidebarStyle := SidebarStyle
+	chatStyle := BorderStyle.BorderForeground(ColorGray)
+	inputStyle := InputStyle.BorderForeground(ColorGray)
+	switch m.focus {
+	case focusSidebar:
+		sidebarStyle = sidebarStyle.BorderForeground(ColorGreen)
+	case focusViewport:
+		chatStyle = chatStyle.BorderForeground(ColorGreen)
+	default:
+		inputStyle = InputStyle
+	}
 
-	chatPane := BorderStyle.Width(m.width - 25).Height(m.height - 7).Render(m.viewport.View())
+	sidebar := sidebarStyle.Width(20).Height(m.height - 7 - m.composerExtra()).Render(sidebarContent)
 
-	inputPane := InputStyle.Width(m.width - 5).Render(m.messageIn.View())
+	chatView := m.viewport.View()
+	switch {
+	case m.showHelp:
+		chatView = m.helpView()
+	case m.palette != nil:
+		chatView = m.paletteView()
+	}
+	chatPane := chatStyle.Width(m.width - 25).Height(m.height - 7 - m.composerExtra()).Render(chatView)
+
+	inputPane := inputStyle.Width(m.width - 5).Render(m.messageIn.View())
 
 	// Status Bar
 	statusMode := "SECURE P2P"
-	statusInfo := fmt.Sprintf("ID: %s... | PEERS: %d", m.host.P2PHost.ID().String()[:10], len(m.peers)-1)
+	presence, _ := m.session.Status()
+	statusInfo := fmt.Sprintf("ID: %s... | %s | PEERS: %d", m.host.P2PHost.ID().String()[:10], strings.ToUpper(presence), len(conversations)-1)
+	if typing := m.typingLine(); typing != "" {
+		statusInfo += " | " + typing
+	}
+	if m.activePeer != session.GlobalRoom {
+		switch m.host.ConnKind(m.activePeer) {
+		case "direct":
+			statusInfo += " | LINK: DIRECT"
+		case "relayed":
+			statusInfo += " | LINK: RELAYED"
+		default:
+			statusInfo += " | LINK: OFFLINE"
+		}
+	}
+
+	if m.selected >= 0 {
+		statusInfo = selectionHelp
+	}
+	if hint := m.completionHint(); hint != "" {
+		statusInfo = hint
+	}
+	if m.confirmQuit {
+		statusInfo = quitPrompt
+	}
 
 	statusBar := lipgloss.NewStyle().
 		Width(m.width).
@@ -395,35 +629,84 @@ func (m Model) View() string {
 }
 
 func (m *Model) updateView() {
+	if m.showLogs {
+		lines, seq := logging.Recent(m.viewport.Height)
+		m.logSeq = seq
+		m.viewport.SetContent("RECENT LOGS (/logs to close)\n" + strings.Join(lines, "\n"))
+		m.viewport.GotoBottom()
+		return
+	}
+
 	var sb strings.Builder
-	for _, msg := range m.messages {
-		timeStr := TimeStyle.Render(time.Unix(msg.Timestamp, 0).Format("15:04"))
-		prefix := ReceiverStyle.Render("THEM")
-		if msg.IsSent {
-			prefix = SenderStyle.Render("YOU")
+	if m.thread != nil {
+		sb.WriteString(NoticeStyle.Render("THREAD (esc to close)") + "\n")
+		for _, msg := range m.thread {
+			sb.WriteString(m.renderMessage(msg, ""))
+		}
+		m.viewport.SetContent(sb.String())
+		m.viewport.GotoTop()
+		return
+	}
+
+	selectedLine := -1
+	for i, msg := range m.messages {
+		marker := ""
+		if m.selected >= 0 {
+			marker = "  "
+			if i == m.selected {
+				marker = ActiveStyle.Render(">") + " "
+				selectedLine = strings.Count(sb.String(), "\n")
+			}
 		}
-		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", timeStr, prefix, msg.Content))
+		sb.WriteString(m.renderMessage(msg, marker))
+	}
+	sb.WriteString(m.renderTransfers())
+	if m.dial != nil {
+		sb.WriteString(m.renderDial())
 	}
 	m.viewport.SetContent(sb.String())
-	m.viewport.GotoBottom()
+	if selectedLine < 0 {
+		m.viewport.GotoBottom()
+		return
+	}
+	// Keep the selected message in the middle of the pane
+	m.viewport.SetYOffset(selectedLine - m.viewport.Height/2)
 }
 
 // Commands
 type historyMsg struct {
 	messages []storage.Message
 }
-type p2pMsg struct {
-	peerID  string
-	content string
-}
+type sessionEventMsg session.Event
 type peersFoundMsg struct {
 	peers []string
 }
+type peerEventMsg p2p.PeerEvent
+type typingExpireMsg struct{}
 type errMsg struct{ err error }
+type logTickMsg struct{}
+
+func logTickCmd() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return logTickMsg{} })
+}
+
+func typingExpireCmd() tea.Cmd {
+	return tea.Tick(session.TypingTimeout, func(time.Time) tea.Msg { return typingExpireMsg{} })
+}
+
+// loadMessages reloads the active conversation synchronously.
+func (m *Model) loadMessages() {
+	msgs, err := m.session.Messages(m.activePeer)
+	if err != nil {
+		log.Error("failed to load history", "peer", m.activePeer, "err", err)
+		return
+	}
+	m.messages = msgs
+}
 
 func (m Model) loadHistoryCmd() tea.Cmd {
 	return func() tea.Msg {
-		msgs, err := storage.GetMessages(m.activePeer, 50)
+		msgs, err := m.session.Messages(m.activePeer)
 		if err != nil {
 			return errMsg{err}
 		}
@@ -433,7 +716,11 @@ func (m Model) loadHistoryCmd() tea.Cmd {
 
 func (m Model) findPeersCmd() tea.Cmd {
 	return func() tea.Msg {
-		// Mock discovery trigger, real logic runs in background in discovery.go
-		return nil
+		// Discovery itself runs in the background (discovery.go) and reports
+		// through peer events; this only picks up peers that beat us to it.
+		if m.host == nil {
+			return nil
+		}
+		return peersFoundMsg{peers: m.host.ChatPeers()}
 	}
 }
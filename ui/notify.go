@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"shellchat/notify"
+	"shellchat/session"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// notifyMethod reads notifications.method, falling back to the bell.
+func notifyMethod(method string) string {
+	if !notify.Valid(method) {
+		log.Warn("unknown notifications.method, using bell", "value", method)
+		return notify.MethodBell
+	}
+	return method
+}
+
+// terminal is the output of the TUI program. bubbletea writes each frame
+// in one call, and the lock keeps notification sequences from landing
+// inside one. The embedded file lets bubbletea find the terminal size.
+type terminal struct {
+	*os.File
+	mu sync.Mutex
+}
+
+func (t *terminal) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.File.Write(b)
+}
+
+func (t *terminal) WriteString(s string) (int, error) {
+	return t.Write([]byte(s))
+}
+
+// notifyCmd announces n by the configured method. Terminal sequences go to
+// out between the frames bubbletea renders there.
+func notifyCmd(out io.Writer, method string, n session.Notification) tea.Cmd {
+	return func() tea.Msg {
+		switch method {
+		case notify.MethodOff:
+		case notify.MethodDBus:
+			if err := notify.DBus(n.Title, n.Body); err != nil {
+				log.Warn("desktop notification failed", "err", err)
+			}
+		default:
+			_, _ = io.WriteString(out, notify.Terminal(method, n.Title, n.Body))
+		}
+		return nil
+	}
+}
+
+// toggleMute handles /mute for the open conversation.
+func (m *Model) toggleMute() string {
+	muted, err := m.session.ToggleMute(m.activePeer)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	if muted {
+		return "Notifications muted for this conversation."
+	}
+	return "Notifications unmuted for this conversation."
+}
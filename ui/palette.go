@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/commands"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// palette is the ctrl+p command picker. It replaces the chat pane while
+// open and filters the commands as the user types.
+type palette struct {
+	query   string
+	matches []commands.Command
+	cursor  int
+}
+
+func (m *Model) openPalette() {
+	m.palette = &palette{matches: tuiCommands.Search("")}
+}
+
+// handlePaletteKey runs one key press while the palette is open.
+func (m *Model) handlePaletteKey(key tea.KeyMsg) tea.Cmd {
+	p := m.palette
+	switch key.Type {
+	case tea.KeyEsc, tea.KeyCtrlP:
+		m.palette = nil
+		return nil
+	case tea.KeyUp, tea.KeyCtrlK:
+		if p.cursor > 0 {
+			p.cursor--
+		}
+		return nil
+	case tea.KeyDown, tea.KeyCtrlJ, tea.KeyCtrlN:
+		if p.cursor < len(p.matches)-1 {
+			p.cursor++
+		}
+		return nil
+	case tea.KeyEnter:
+		if len(p.matches) == 0 {
+			return nil
+		}
+		cmd := p.matches[p.cursor]
+		m.palette = nil
+		// Commands that need an argument wait for it in the composer
+		if cmd.Arg != commands.ArgNone {
+			m.messageIn.SetValue("/" + cmd.Name + " ")
+			m.stopRecall()
+			return nil
+		}
+		m.messageIn.SetValue("")
+		return m.runCommand("/" + cmd.Name)
+	case tea.KeyBackspace:
+		if p.query == "" {
+			return nil
+		}
+		runes := []rune(p.query)
+		p.query = string(runes[:len(runes)-1])
+	case tea.KeyRunes, tea.KeySpace:
+		p.query += string(key.Runes)
+	default:
+		return nil
+	}
+	p.matches = tuiCommands.Search(p.query)
+	p.cursor = 0
+	return nil
+}
+
+// paletteView renders the palette in place of the chat pane.
+func (m Model) paletteView() string {
+	p := m.palette
+	var sb strings.Builder
+	sb.WriteString(NoticeStyle.Render("COMMAND PALETTE") + TimeStyle.Render("  ↑/↓ select · enter run · esc close") + "\n")
+	sb.WriteString("> " + p.query + "\n\n")
+	if len(p.matches) == 0 {
+		sb.WriteString(TimeStyle.Render("No matching commands."))
+	}
+	visible := max(m.viewport.Height-3, 1)
+	start := max(0, p.cursor-visible+1)
+	for i := start; i < len(p.matches) && i < start+visible; i++ {
+		c := p.matches[i]
+		line := fmt.Sprintf("%-22s %s", c.Synopsis(), TimeStyle.Render(c.Help))
+		if i == p.cursor {
+			line = ActiveStyle.Render(fmt.Sprintf("%-22s", c.Synopsis())) + " " + c.Help
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"shellchat/p2p"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// idleCheckInterval is how often auto-away looks at the last keypress.
+const idleCheckInterval = 15 * time.Second
+
+type idleTickMsg struct{}
+
+func idleTickCmd() tea.Cmd {
+	return tea.Tick(idleCheckInterval, func(time.Time) tea.Msg { return idleTickMsg{} })
+}
+
+// parseIdleTime reads an idle time from the setting called name; zero
+// disables what it triggers.
+func parseIdleTime(name, s string) time.Duration {
+	if s == "" || s == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Warn("invalid idle time, disabled", "setting", name, "value", s, "err", err)
+		return 0
+	}
+	return d
+}
+
+// setStatus handles /status and returns what to show in the chat pane.
+func (m *Model) setStatus(arg string) string {
+	if m.host == nil {
+		return "Not connected to the P2P network."
+	}
+	if arg == "" {
+		state, text := m.session.Status()
+		if text == "" {
+			return fmt.Sprintf("Status: %s", state)
+		}
+		return fmt.Sprintf("Status: %s - %s", state, text)
+	}
+
+	m.autoAway = false
+	state, text := m.session.SetStatus(arg)
+	if text == "" {
+		return fmt.Sprintf("Status set to %s.", state)
+	}
+	return fmt.Sprintf("Status set to %s - %s.", state, text)
+}
+
+// markActive records user input and undoes an automatic away.
+func (m *Model) markActive() {
+	m.lastInput = time.Now()
+	if !m.autoAway || m.host == nil {
+		return
+	}
+	m.autoAway = false
+	m.session.SetPresence(p2p.PresenceOnline)
+}
+
+// checkIdle switches to away after the configured idle time. A state the
+// user picked themselves is left alone.
+func (m *Model) checkIdle() {
+	if m.idleAfter == 0 || m.autoAway || m.host == nil {
+		return
+	}
+	state, _ := m.session.Status()
+	if state != p2p.PresenceOnline || time.Since(m.lastInput) < m.idleAfter {
+		return
+	}
+	m.autoAway = true
+	m.session.SetPresence(p2p.PresenceAway)
+}
+
+// presenceDot marks a contact in the sidebar. Peers we are not connected to
+// are offline whatever they last published.
+func (m Model) presenceDot(p string) string {
+	if !m.session.Online(p) {
+		return OfflineStyle.Render("○")
+	}
+	switch m.session.Contact(p).Presence {
+	case p2p.PresenceAway:
+		return AwayStyle.Render("◐")
+	case p2p.PresenceDND:
+		return BusyStyle.Render("⊘")
+	}
+	return OnlineStyle.Render("●")
+}
+
+// peerStatus is the status text shown under a connected contact.
+func (m Model) peerStatus(p string, width int) string {
+	text := m.session.Contact(p).StatusText
+	if !m.session.Online(p) || text == "" {
+		return ""
+	}
+	runes := []rune(text)
+	if len(runes) > width {
+		text = string(runes[:width-1]) + "…"
+	}
+	return TimeStyle.Render(text)
+}
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/session"
+	"shellchat/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// quickReactions are bound to 1-6 in selection mode.
+var quickReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// toggleReaction adds or takes back our emoji on msg and tells our peers.
+func (m *Model) toggleReaction(msg *storage.Message, emoji string) tea.Cmd {
+	if msg == nil || msg.MsgID == "" || msg.Deleted {
+		m.viewport.SetContent("Nothing to react to.")
+		return nil
+	}
+	if !session.ValidReaction(emoji) {
+		m.viewport.SetContent("Usage: /react <emoji>")
+		return nil
+	}
+	if _, err := m.session.React(msg.MsgID, emoji); err != nil {
+		log.Error("failed to save reaction", "err", err)
+		m.viewport.SetContent(fmt.Sprintf("Error: %v", err))
+		return nil
+	}
+	return m.loadHistoryCmd()
+}
+
+// lastReactable returns the newest message /react can target.
+func (m *Model) lastReactable() *storage.Message {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := &m.messages[i]; msg.MsgID != "" && !msg.Deleted {
+			return msg
+		}
+	}
+	return nil
+}
+
+// reactionsLine renders the reaction counts under a message, or "".
+func reactionsLine(msg storage.Message) string {
+	if len(msg.Reactions) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(msg.Reactions))
+	for _, r := range msg.Reactions {
+		part := fmt.Sprintf("%s %d", r.Emoji, r.Count)
+		if r.Mine {
+			part = NoticeStyle.Render(part)
+		} else {
+			part = TimeStyle.Render(part)
+		}
+		parts = append(parts, part)
+	}
+	return "        " + strings.Join(parts, "  ")
+}
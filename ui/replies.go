@@ -0,0 +1,101 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// quotePreviewLen is how much of an answered message a reply shows.
+const quotePreviewLen = 50
+
+type threadMsg struct {
+	messages []storage.Message
+	err      error
+}
+
+// startReply makes the next message sent an answer to msg.
+func (m *Model) startReply(msg *storage.Message) {
+	if msg.MsgID == "" || msg.Deleted {
+		return
+	}
+	if m.editing != nil {
+		m.cancelEdit()
+	}
+	reply := *msg
+	m.replyTo = &reply
+	m.selected = -1
+	m.messageIn.Placeholder = "Reply to " + preview(messageAuthor(msg.IsSent), msg.Content) + " (esc cancels)"
+}
+
+func (m *Model) cancelReply() {
+	m.replyTo = nil
+	m.messageIn.Placeholder = messagePlaceholder
+}
+
+// openThread handles /thread and t in selection mode. Without a message it
+// picks the newest reply in the conversation.
+func (m *Model) openThread(msg *storage.Message) tea.Cmd {
+	if msg == nil {
+		for i := len(m.messages) - 1; i >= 0; i-- {
+			if m.messages[i].ReplyTo != "" {
+				msg = &m.messages[i]
+				break
+			}
+		}
+	}
+	if msg == nil || msg.MsgID == "" {
+		m.viewport.SetContent("No replies in this conversation.")
+		return nil
+	}
+	m.selected = -1
+	msgID := msg.MsgID
+	s := m.session
+	return func() tea.Msg {
+		msgs, err := s.Thread(msgID)
+		return threadMsg{messages: msgs, err: err}
+	}
+}
+
+func (m *Model) closeThread() {
+	m.thread = nil
+	m.updateView()
+}
+
+// quoteLine renders the preview of the message msg answers, or "".
+func quoteLine(msg storage.Message) string {
+	if msg.ReplyTo == "" {
+		return ""
+	}
+	text := "original message not available"
+	if q := msg.Quote; q != nil {
+		text = preview(messageAuthor(q.IsSent), q.Content)
+		if q.Content == "" {
+			text = "message deleted"
+		}
+	}
+	return TimeStyle.Render("        ┃ " + text)
+}
+
+func messageAuthor(isSent bool) string {
+	if isSent {
+		return "YOU"
+	}
+	return "THEM"
+}
+
+// preview shortens content to one line for quotes and placeholders.
+func preview(author, content string) string {
+	line, _, multi := strings.Cut(content, "\n")
+	runes := []rune(line)
+	if len(runes) > quotePreviewLen {
+		line, multi = string(runes[:quotePreviewLen]), true
+	}
+	if multi {
+		line += "…"
+	}
+	return fmt.Sprintf("%s: %s", author, line)
+}
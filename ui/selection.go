@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"shellchat/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Selection mode is entered with alt+↑ or ctrl+↑. It picks a message in the
+// viewport for the actions below; esc leaves it.
+const selectionHelp = "↑/↓ select · r reply · t thread · 1-6 react · e edit · d delete · h history · esc back"
+
+// startSelection selects the newest message, if there is one.
+func (m *Model) startSelection() bool {
+	if len(m.messages) == 0 || m.showLogs || m.thread != nil {
+		return false
+	}
+	m.selected = len(m.messages) - 1
+	m.updateView()
+	return true
+}
+
+func (m *Model) stopSelection() {
+	m.selected = -1
+	m.updateView()
+}
+
+// selectedMessage returns the selected message, or nil outside selection mode.
+func (m *Model) selectedMessage() *storage.Message {
+	if m.selected < 0 || m.selected >= len(m.messages) {
+		return nil
+	}
+	return &m.messages[m.selected]
+}
+
+// handleSelectionKey runs one key press in selection mode.
+func (m *Model) handleSelectionKey(key tea.KeyMsg) tea.Cmd {
+	msg := m.selectedMessage()
+	if msg == nil {
+		m.stopSelection()
+		return nil
+	}
+
+	switch key.String() {
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "down", "j":
+		if m.selected == len(m.messages)-1 {
+			m.stopSelection()
+			return nil
+		}
+		m.selected++
+	case "esc":
+		m.stopSelection()
+		return nil
+	case "e":
+		if !canChange(msg) || msg.File != nil {
+			return nil
+		}
+		m.cancelReply()
+		edit := *msg
+		m.editing = &edit
+		m.selected = -1
+		m.messageIn.SetValue(msg.Content)
+		m.messageIn.CursorEnd()
+		m.messageIn.Placeholder = "Edit message (esc cancels)"
+	case "d":
+		if !canChange(msg) {
+			return nil
+		}
+		if err := m.session.Delete(msg.MsgID); err != nil {
+			log.Error("failed to delete message", "err", err)
+			return nil
+		}
+		m.selected = -1
+		return m.loadHistoryCmd()
+	case "r":
+		m.startReply(msg)
+	case "t":
+		return m.openThread(msg)
+	case "1", "2", "3", "4", "5", "6":
+		return m.toggleReaction(msg, quickReactions[key.Runes[0]-'1'])
+	case "h":
+		if msg.EditedAt == 0 {
+			return nil
+		}
+		m.viewport.SetContent(m.renderEdits(*msg))
+		return nil
+	}
+	m.updateView()
+	return nil
+}
+
+// canChange reports whether we may edit or delete msg: only our own live
+// messages with an ID peers know them by.
+func canChange(msg *storage.Message) bool {
+	return msg.IsSent && !msg.Deleted && msg.MsgID != ""
+}
+
+// finishEdit saves and sends the edited text of m.editing.
+func (m *Model) finishEdit(content string) tea.Cmd {
+	msg := m.editing
+	m.cancelEdit()
+	if content == "" || content == msg.Content {
+		m.updateView()
+		return nil
+	}
+	if err := m.session.Edit(msg.MsgID, content); err != nil {
+		log.Error("failed to edit message", "err", err)
+		m.viewport.SetContent(fmt.Sprintf("Error: %v", err))
+		return nil
+	}
+	return m.loadHistoryCmd()
+}
+
+func (m *Model) cancelEdit() {
+	m.editing = nil
+	m.messageIn.SetValue("")
+	m.messageIn.Placeholder = messagePlaceholder
+}
+
+func (m *Model) renderEdits(msg storage.Message) string {
+	edits, err := m.session.Edits(msg.ID)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	var sb strings.Builder
+	sb.WriteString("EDIT HISTORY (esc to close)\n")
+	for _, e := range edits {
+		sb.WriteString(fmt.Sprintf("[%s] %s\n", TimeStyle.Render(time.Unix(e.ReplacedAt, 0).Format("Jan 2 15:04")), e.Content))
+	}
+	sb.WriteString(fmt.Sprintf("[%s] %s\n", TimeStyle.Render("now"), msg.Content))
+	return sb.String()
+}
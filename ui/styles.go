@@ -1,54 +1,126 @@
 package ui
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"shellchat/theme"
 
-// Retro Color Palette (Green/Amber Monochrome)
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Palette of the current theme; see useTheme
 var (
-	ColorGreen = lipgloss.Color("2") // Standard Terminal Green
-	ColorDark  = lipgloss.Color("0") // Black
-	ColorGray  = lipgloss.Color("8") // Gray for borders
-	ColorAmber = lipgloss.Color("3") // Amber/Yellow for highlights
-	ColorRed   = lipgloss.Color("1") // Red for errors
+	ColorGreen lipgloss.Color // Text, our messages, the active item
+	ColorDark  lipgloss.Color // Background, text on green
+	ColorGray  lipgloss.Color // Gray for borders
+	ColorAmber lipgloss.Color // Amber/Yellow for highlights
+	ColorRed   lipgloss.Color // Red for errors
 )
 
-// Retro Styles
+// Styles, rebuilt from the palette by useTheme
 var (
 	// Borders
-	BorderStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(ColorGreen).
-			Padding(0, 1)
+	BorderStyle lipgloss.Style
 
 	// Sidebar
-	SidebarStyle = BorderStyle.Copy().
-			BorderForeground(ColorGray).
-			MarginRight(1)
+	SidebarStyle lipgloss.Style
 
 	// Active Tab/Peer
-	ActiveStyle = lipgloss.NewStyle().
-			Foreground(ColorDark).
-			Background(ColorGreen).
-			Bold(true)
+	ActiveStyle lipgloss.Style
 
 	// Inactive
-	InactiveStyle = lipgloss.NewStyle().
-			Foreground(ColorGreen)
+	InactiveStyle lipgloss.Style
 
 	// Messages
+	SenderStyle   lipgloss.Style
+	ReceiverStyle lipgloss.Style
+	TimeStyle     lipgloss.Style
+
+	// Connection progress
+	ErrorStyle  lipgloss.Style
+	NoticeStyle lipgloss.Style
+
+	// Presence dots
+	OnlineStyle  lipgloss.Style
+	OfflineStyle lipgloss.Style
+	AwayStyle    lipgloss.Style
+	BusyStyle    lipgloss.Style
+
+	// Unread badges
+	UnreadStyle lipgloss.Style
+
+	// Input
+	InputStyle lipgloss.Style
+)
+
+// lightTheme is set while the theme is meant for a light background.
+var lightTheme bool
+
+func init() {
+	useTheme(theme.Builtin()[0])
+}
+
+// useTheme sets the palette and rebuilds the styles from it. Widgets that
+// keep copies of the styles are updated by Model.setTheme.
+func useTheme(t theme.Theme) {
+	ColorGreen = lipgloss.Color(t.Foreground)
+	ColorDark = lipgloss.Color(t.Background)
+	ColorGray = lipgloss.Color(t.Muted)
+	ColorAmber = lipgloss.Color(t.Accent)
+	ColorRed = lipgloss.Color(t.Error)
+	lightTheme = t.Light
+
+	BorderStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(ColorGreen).
+		Padding(0, 1)
+
+	SidebarStyle = BorderStyle.
+		BorderForeground(ColorGray).
+		MarginRight(1)
+
+	ActiveStyle = lipgloss.NewStyle().
+		Foreground(ColorDark).
+		Background(ColorGreen).
+		Bold(true)
+
+	InactiveStyle = lipgloss.NewStyle().
+		Foreground(ColorGreen)
+
 	SenderStyle = lipgloss.NewStyle().
-			Foreground(ColorGreen).
-			Bold(true)
+		Foreground(ColorGreen).
+		Bold(true)
 
 	ReceiverStyle = lipgloss.NewStyle().
-			Foreground(ColorAmber).
-			Bold(true)
+		Foreground(ColorAmber).
+		Bold(true)
 
 	TimeStyle = lipgloss.NewStyle().
-			Foreground(ColorGray)
+		Foreground(ColorGray)
+
+	ErrorStyle = lipgloss.NewStyle().
+		Foreground(ColorRed)
+
+	NoticeStyle = lipgloss.NewStyle().
+		Foreground(ColorGreen).
+		Bold(true)
+
+	OnlineStyle = lipgloss.NewStyle().
+		Foreground(ColorGreen)
+
+	OfflineStyle = lipgloss.NewStyle().
+		Foreground(ColorGray)
+
+	AwayStyle = lipgloss.NewStyle().
+		Foreground(ColorAmber)
+
+	BusyStyle = lipgloss.NewStyle().
+		Foreground(ColorRed)
+
+	UnreadStyle = lipgloss.NewStyle().
+		Foreground(ColorAmber).
+		Bold(true)
 
-	// Input
 	InputStyle = lipgloss.NewStyle().
-			Border(lipgloss.DoubleBorder()).
-			BorderForeground(ColorGreen).
-			Padding(0, 1)
-)
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(ColorGreen).
+		Padding(0, 1)
+}
@@ -0,0 +1,54 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"shellchat/config"
+	"shellchat/theme"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// loadThemes returns the built-in themes and those in the data directory.
+func loadThemes() []theme.Theme {
+	dir, err := config.Dir()
+	if err != nil {
+		log.Warn("no config directory, using built-in themes", "err", err)
+		return theme.Builtin()
+	}
+	themes, err := theme.Load(dir)
+	if err != nil {
+		log.Warn("failed to load some themes", "err", err)
+	}
+	return themes
+}
+
+// configuredTheme returns the theme named in config.json, or the default.
+func configuredTheme(themes []theme.Theme, name string) theme.Theme {
+	if t, ok := theme.Find(themes, name); ok {
+		return t
+	}
+	if name != "" {
+		log.Warn("unknown theme, using the default", "theme", name)
+	}
+	t, _ := theme.Find(themes, theme.Default)
+	return t
+}
+
+// setTheme switches the palette and restyles the widgets that keep their
+// own copies of the styles.
+func (m *Model) setTheme(t theme.Theme) {
+	useTheme(t)
+	m.themeName = t.Name
+	m.passwordIn.PromptStyle = lipgloss.NewStyle().Foreground(ColorGreen)
+	m.passwordIn.TextStyle = lipgloss.NewStyle().Foreground(ColorGreen)
+	m.messageIn.FocusedStyle.Prompt = lipgloss.NewStyle().Foreground(ColorGreen)
+	m.messageIn.FocusedStyle.Text = lipgloss.NewStyle().Foreground(ColorGreen)
+	m.spinner.Style = lipgloss.NewStyle().Foreground(ColorGreen)
+}
+
+// themeList describes the current theme and the others available.
+func (m *Model) themeList() string {
+	return fmt.Sprintf("Theme: %s\nAvailable: %s\n", m.themeName, strings.Join(theme.Names(m.themes), ", "))
+}